@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// accessLog wraps a handler to write an access log line for every request to
+// stdout in nginx's combined format, followed by the duration in seconds and
+// the request ID. It does nothing unless ACCESS_LOG is set.
+func (s *Server) accessLog(next http.Handler) http.Handler {
+	if !s.cfg.AccessLog {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		user, _, ok := r.BasicAuth()
+		if !ok || user == "" {
+			user = "-"
+		}
+		fmt.Fprintf(os.Stdout, "%s - %s [%s] \"%s %s %s\" %d %d %q %q %.3f %s\n",
+			host, user, start.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method, s.redactRequestURI(r.URL), r.Proto,
+			rec.status, rec.bytes, orDash(r.Referer()), orDash(r.UserAgent()),
+			time.Since(start).Seconds(), orDash(w.Header().Get("X-Request-ID")))
+	})
+}
+
+// redactRequestURI returns the path and query of a request URL with API keys
+// replaced and Tautulli URLs reduced to their scheme and host, so the access
+// log never contains credentials.
+func (s *Server) redactRequestURI(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.EscapedPath()
+	}
+	query := u.Query()
+	for name, values := range query {
+		for i, value := range values {
+			switch name {
+			case "api_key":
+				values[i] = "REDACTED"
+			case "tautulli_url":
+				urls := splitList(value)
+				for j := range urls {
+					urls[j] = redactURL(normalizeURL(urls[j], s.cfg.DefaultScheme))
+				}
+				values[i] = strings.Join(urls, ",")
+			}
+		}
+	}
+	return u.EscapedPath() + "?" + query.Encode()
+}
+
+// orDash returns s, or "-" when it is empty, as access logs show missing
+// values.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
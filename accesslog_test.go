@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// captureStdout returns what f writes to stdout.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	f()
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestAccessLog(t *testing.T) {
+	upstream, _ := fakeTautulli(t, activityBody(episodeJSON))
+	cfg := testConfig()
+	cfg.AccessLog = true
+	cfg.AllowedHosts = []string{"127.0.0.1"}
+	s := newServer(cfg)
+
+	out := captureStdout(t, func() {
+		req := httptest.NewRequest(http.MethodGet, "/?api_key=secret&tautulli_url="+upstream.URL+"/tautulli&layout=quadrant", nil)
+		req.Header.Set("User-Agent", "trmnl/1.0")
+		req.Header.Set("X-Request-ID", "abc123")
+		s.routes().ServeHTTP(httptest.NewRecorder(), req)
+	})
+
+	line := regexp.MustCompile(`^192\.0\.2\.1 - - \[[^\]]+\] "GET (\S+) HTTP/1\.1" 200 (\d+) "-" "trmnl/1\.0" \d+\.\d{3} abc123\n$`)
+	match := line.FindStringSubmatch(out)
+	if match == nil {
+		t.Fatalf("unexpected access log line: %q", out)
+	}
+	if strings.Contains(out, "secret") || strings.Contains(out, "/tautulli") {
+		t.Errorf("the access log line leaks credentials or paths: %q", out)
+	}
+	if !strings.Contains(match[1], "api_key=REDACTED") || match[2] == "0" {
+		t.Errorf("got path %q and %s bytes, want the API key masked and the body size", match[1], match[2])
+	}
+}
+
+func TestAccessLogDisabled(t *testing.T) {
+	upstream, _ := fakeTautulli(t, activityBody())
+	s := configuredServer(upstream)
+	if out := captureStdout(t, func() { get(s, "/") }); out != "" {
+		t.Errorf("wrote %q without ACCESS_LOG", out)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+)
+
+// requireAuth wraps a handler to require HTTP Basic Auth when credentials are
+// configured. Config.validate makes sure AuthUser and AuthPass are set
+// together, so authentication is only disabled when both are empty.
+func (s *Server) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.cfg.AuthUser == "" && s.cfg.AuthPass == "" {
+			next(w, r)
+			return
+		}
+
+		user, pass, ok := r.BasicAuth()
+		if !ok || !secureEqual(user, s.cfg.AuthUser) || !secureEqual(pass, s.cfg.AuthPass) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="tautulli-trmnl", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// secureEqual compares two strings in constant time. Hashing first keeps the
+// comparison from leaking the length of the expected value.
+func secureEqual(a, b string) bool {
+	aHash := sha256.Sum256([]byte(a))
+	bHash := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(aHash[:], bHash[:]) == 1
+}
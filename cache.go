@@ -0,0 +1,92 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// activityCache stores decoded Tautulli responses keyed by server and API key,
+// so that several devices polling within the TTL share a single upstream call.
+// Entries are kept past the TTL for up to staleTTL so they can be served when
+// Tautulli is unreachable.
+type activityCache struct {
+	mu       sync.Mutex
+	staleTTL time.Duration
+	entries  map[string]cacheEntry
+	pruned   time.Time
+}
+
+type cacheEntry struct {
+	data    TautulliResponse
+	fetched time.Time
+	ttl     time.Duration
+}
+
+// newActivityCache returns a cache that keeps entries as stale fallbacks for
+// staleTTL. Each entry is served for the TTL it is stored with; a TTL of zero
+// or less doesn't serve it at all, and a staleTTL of zero or less disables
+// the stale fallback.
+func newActivityCache(staleTTL time.Duration) *activityCache {
+	return &activityCache{
+		staleTTL: staleTTL,
+		entries:  make(map[string]cacheEntry),
+	}
+}
+
+// get returns the cached response for key if it is younger than the TTL it
+// was stored with.
+func (c *activityCache) get(key string) (TautulliResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Since(entry.fetched) >= entry.ttl {
+		return TautulliResponse{}, false
+	}
+	return entry.data, true
+}
+
+// getStale returns the last response stored for key and when it was fetched,
+// as long as it is younger than the stale TTL.
+func (c *activityCache) getStale(key string) (TautulliResponse, time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return TautulliResponse{}, time.Time{}, false
+	}
+	if time.Since(entry.fetched) >= c.staleTTL {
+		delete(c.entries, key)
+		return TautulliResponse{}, time.Time{}, false
+	}
+	return entry.data, entry.fetched, true
+}
+
+// set stores data under key, to be served for ttl.
+func (c *activityCache) set(key string, data TautulliResponse, ttl time.Duration) {
+	if ttl <= 0 && c.staleTTL <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.prune(now)
+	c.entries[key] = cacheEntry{data: data, fetched: now, ttl: ttl}
+}
+
+// prune drops entries that can no longer be served, fresh or stale, so keys
+// that are never requested again don't pile up. It runs at most once a
+// minute.
+func (c *activityCache) prune(now time.Time) {
+	if now.Sub(c.pruned) < time.Minute {
+		return
+	}
+	c.pruned = now
+	for key, entry := range c.entries {
+		if now.Sub(entry.fetched) >= max(entry.ttl, c.staleTTL) {
+			delete(c.entries, key)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestActivityIsCachedForTTL(t *testing.T) {
+	upstream, requests := fakeTautulli(t, activityBody(episodeJSON))
+	cfg := testConfig()
+	cfg.TautulliURL = upstream.URL
+	cfg.APIKey = "key"
+	cfg.CacheTTL = Duration(100 * time.Millisecond)
+	s := newServer(cfg)
+
+	decodePage(t, get(s, "/"))
+	decodePage(t, get(s, "/?layout=quadrant"))
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("Tautulli got %d requests within the TTL, want 1", n)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	decodePage(t, get(s, "/"))
+	if n := requests.Load(); n != 2 {
+		t.Errorf("Tautulli got %d requests after the TTL expired, want 2", n)
+	}
+}
+
+func TestActivityCache(t *testing.T) {
+	cache := newActivityCache(time.Hour)
+	var data TautulliResponse
+	data.Response.Result = "success"
+
+	cache.set("fresh", data, time.Hour)
+	if _, ok := cache.get("fresh"); !ok {
+		t.Error("fresh entry was not served")
+	}
+
+	cache.set("expired", data, -time.Second)
+	if _, ok := cache.get("expired"); ok {
+		t.Error("expired entry was served")
+	}
+	if _, _, ok := cache.getStale("expired"); !ok {
+		t.Error("expired entry is not kept as a stale fallback")
+	}
+
+	if _, ok := cache.get("missing"); ok {
+		t.Error("missing entry was served")
+	}
+}
+
+func TestActivityCachePrunesExpiredEntries(t *testing.T) {
+	cache := newActivityCache(time.Hour)
+	cache.set("stale", TautulliResponse{}, time.Minute)
+	cache.set("long", TautulliResponse{}, 2*time.Hour)
+
+	now := time.Now()
+	cache.prune(now.Add(30 * time.Minute))
+	if len(cache.entries) != 2 {
+		t.Fatalf("got %d entries, want entries still usable as stale fallbacks kept", len(cache.entries))
+	}
+
+	cache.prune(now.Add(90 * time.Minute))
+	if _, ok := cache.entries["stale"]; ok {
+		t.Error("an entry past the stale TTL was kept")
+	}
+	if _, ok := cache.entries["long"]; !ok {
+		t.Error("an entry within its own TTL was dropped")
+	}
+
+	// Pruning runs at most once a minute.
+	cache.entries["old"] = cacheEntry{fetched: now}
+	cache.prune(now.Add(90*time.Minute + 59*time.Second))
+	if _, ok := cache.entries["old"]; !ok {
+		t.Error("prune ran again within a minute")
+	}
+	cache.prune(now.Add(92 * time.Minute))
+	if _, ok := cache.entries["old"]; ok {
+		t.Error("prune didn't run after a minute")
+	}
+}
+
+func TestSetPrunesTheCache(t *testing.T) {
+	cache := newActivityCache(time.Hour)
+	cache.entries["old"] = cacheEntry{fetched: time.Now().Add(-2 * time.Hour), ttl: time.Minute}
+	cache.set("new", TautulliResponse{}, time.Minute)
+	if _, ok := cache.entries["old"]; ok {
+		t.Error("set left an expired entry in the cache")
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// cacheControlWriter sets the Cache-Control header just before the status is
+// written, so that errors are never cached.
+type cacheControlWriter struct {
+	http.ResponseWriter
+	value       string
+	wroteHeader bool
+}
+
+func (w *cacheControlWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		value := w.value
+		if status >= http.StatusBadRequest {
+			value = "no-store"
+		}
+		w.Header().Set("Cache-Control", value)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheControlWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// cacheControl wraps a handler to tell the device and any caches in between
+// how long its response may be reused: RESPONSE_MAX_AGE, or not at all when
+// that isn't set.
+func (s *Server) cacheControl(next http.HandlerFunc) http.HandlerFunc {
+	value := "no-store"
+	if maxAge := time.Duration(s.cfg.ResponseMaxAge); maxAge > 0 {
+		value = fmt.Sprintf("max-age=%d", int(maxAge.Seconds()))
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(&cacheControlWriter{ResponseWriter: w, value: value}, r)
+	}
+}
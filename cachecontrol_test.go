@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCacheControl(t *testing.T) {
+	upstream, _ := fakeTautulli(t, activityBody(episodeJSON))
+
+	tests := []struct {
+		name       string
+		maxAge     time.Duration
+		target     string
+		wantStatus int
+		want       string
+	}{
+		{"default", 0, "/", http.StatusOK, "no-store"},
+		{"RESPONSE_MAX_AGE", 90 * time.Second, "/", http.StatusOK, "max-age=90"},
+		{"error", 90 * time.Second, "/?bandwidth_limit=x", http.StatusBadRequest, "no-store"}, // Errors are never cached
+	}
+	for _, tt := range tests {
+		cfg := testConfig()
+		cfg.TautulliURL = upstream.URL
+		cfg.APIKey = "key"
+		cfg.ResponseMaxAge = Duration(tt.maxAge)
+
+		rec := get(newServer(cfg), tt.target)
+		if rec.Code != tt.wantStatus {
+			t.Fatalf("%s: status = %d, want %d", tt.name, rec.Code, tt.wantStatus)
+		}
+		if got := rec.Header().Get("Cache-Control"); got != tt.want {
+			t.Errorf("%s: Cache-Control = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
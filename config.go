@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the server settings. They are read from an optional JSON
+// config file, then overridden by environment variables, and finally by
+// command-line flags.
+type Config struct {
+	Port                  string   `json:"port"`
+	BindAddress           string   `json:"bind_address"` // IP address to listen on; empty means all interfaces
+	TLSCertFile           string   `json:"tls_cert_file"`
+	TLSKeyFile            string   `json:"tls_key_file"`
+	TautulliURL           string   `json:"tautulli_url"`
+	DefaultScheme         string   `json:"default_scheme"`
+	BasePath              string   `json:"base_path"`
+	APIKey                string   `json:"api_key"`
+	HTTPTimeout           Duration `json:"http_timeout"`
+	UserAgent             string   `json:"user_agent"`      // Sent to Tautulli; empty means tautulli-trmnl/<version>
+	RequestTimeout        Duration `json:"request_timeout"` // Bounds all the work done for one request, including retries
+	MaxResponseBytes      int      `json:"max_response_bytes"`
+	InsecureSkipVerify    bool     `json:"insecure_skip_verify"`
+	RetryAttempts         int      `json:"retry_attempts"`
+	RetryBaseDelay        Duration `json:"retry_base_delay"`
+	CacheTTL              Duration `json:"cache_ttl"`
+	StaleTTL              Duration `json:"stale_ttl"`
+	PollInterval          Duration `json:"background_poll_interval"` // Poll the configured servers this often; 0 disables
+	ResponseMaxAge        Duration `json:"response_max_age"`
+	MaxSessions           int      `json:"max_sessions"`
+	MaxTitleLength        int      `json:"max_title_length"`
+	BandwidthLimit        int      `json:"bandwidth_limit"` // WAN Mbps above which a warning is shown
+	TimeFormat            string   `json:"time_format"`
+	EmptyMessage          string   `json:"empty_message"`
+	Translations          catalogs `json:"translations"` // Extra languages and overrides, by language code
+	HideUsers             bool     `json:"hide_users"`
+	PlaceholderURL        string   `json:"placeholder_url"`
+	PublicBaseURL         string   `json:"public_base_url"`
+	TrustForwardedHeaders bool     `json:"trust_forwarded_headers"`
+	AllowedHosts          []string `json:"allowed_hosts"`
+	BlockPrivateHosts     bool     `json:"block_private_hosts"`
+	AllowedOrigins        []string `json:"allowed_origins"`
+	AuthUser              string   `json:"auth_user"`
+	AuthPass              string   `json:"auth_pass"`
+	RateLimit             int      `json:"rate_limit"` // Requests per minute per client
+	RateBurst             int      `json:"rate_burst"`
+	ShutdownTimeout       Duration `json:"shutdown_timeout"`
+	AccessLog             bool     `json:"access_log"` // Write an access log line for every request to stdout
+	EnablePprof           bool     `json:"enable_pprof"`
+	PprofAddress          string   `json:"pprof_address"` // Where the pprof endpoints are served when enabled
+}
+
+// Duration is a time.Duration written as a string like "15s" in config files.
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"10s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// defaultConfig returns the settings used when nothing else is configured.
+func defaultConfig() Config {
+	return Config{
+		Port:              "8080",
+		DefaultScheme:     "https",
+		HTTPTimeout:       Duration(10 * time.Second),
+		RequestTimeout:    Duration(15 * time.Second),
+		MaxResponseBytes:  1 << 20,
+		RetryAttempts:     3,
+		RetryBaseDelay:    Duration(200 * time.Millisecond),
+		RateBurst:         5,
+		BlockPrivateHosts: true,
+		CacheTTL:          Duration(15 * time.Second),
+		StaleTTL:          Duration(time.Hour),
+		TimeFormat:        "12h",
+		MaxTitleLength:    40,
+		EmptyMessage:      "Nothing is currently playing.",
+		PlaceholderURL:    defaultPlaceholderURL,
+		ShutdownTimeout:   Duration(10 * time.Second),
+		PprofAddress:      "127.0.0.1:6060",
+	}
+}
+
+// loadConfig returns the default settings overridden by the config file at
+// path, if any, and then by environment variables.
+func loadConfig(path string) (Config, error) {
+	cfg := defaultConfig()
+
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to open config file: %w", err)
+		}
+		defer f.Close()
+
+		dec := json.NewDecoder(f)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&cfg); err != nil {
+			return cfg, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// applyEnv overrides settings with any environment variables that are set.
+func (c *Config) applyEnv() error {
+	envString("PORT", &c.Port)
+	envString("BIND_ADDRESS", &c.BindAddress)
+	envString("TLS_CERT_FILE", &c.TLSCertFile)
+	envString("TLS_KEY_FILE", &c.TLSKeyFile)
+	envString("TAUTULLI_URL", &c.TautulliURL)
+	envString("TAUTULLI_API_KEY", &c.APIKey)
+	envString("DEFAULT_SCHEME", &c.DefaultScheme)
+	envString("TAUTULLI_BASE_PATH", &c.BasePath)
+	envString("USER_AGENT", &c.UserAgent)
+	envString("PPROF_ADDRESS", &c.PprofAddress)
+	envString("TIME_FORMAT", &c.TimeFormat)
+	envString("EMPTY_MESSAGE", &c.EmptyMessage)
+	envString("PLACEHOLDER_URL", &c.PlaceholderURL)
+	envString("PUBLIC_BASE_URL", &c.PublicBaseURL)
+	envString("AUTH_USER", &c.AuthUser)
+	envString("AUTH_PASS", &c.AuthPass)
+	if value := os.Getenv("ALLOWED_TAUTULLI_HOSTS"); value != "" {
+		c.AllowedHosts = strings.Split(value, ",")
+	}
+	if value := os.Getenv("ALLOWED_ORIGINS"); value != "" {
+		c.AllowedOrigins = strings.Split(value, ",")
+	}
+
+	for name, dst := range map[string]*Duration{
+		"HTTP_TIMEOUT":             &c.HTTPTimeout,
+		"REQUEST_TIMEOUT":          &c.RequestTimeout,
+		"RETRY_BASE_DELAY":         &c.RetryBaseDelay,
+		"CACHE_TTL":                &c.CacheTTL,
+		"STALE_TTL":                &c.StaleTTL,
+		"BACKGROUND_POLL_INTERVAL": &c.PollInterval,
+		"RESPONSE_MAX_AGE":         &c.ResponseMaxAge,
+		"SHUTDOWN_TIMEOUT":         &c.ShutdownTimeout,
+	} {
+		if err := envDuration(name, dst); err != nil {
+			return err
+		}
+	}
+	for name, dst := range map[string]*int{
+		"RETRY_ATTEMPTS":     &c.RetryAttempts,
+		"MAX_RESPONSE_BYTES": &c.MaxResponseBytes,
+		"MAX_SESSIONS":       &c.MaxSessions,
+		"MAX_TITLE_LENGTH":   &c.MaxTitleLength,
+		"BANDWIDTH_LIMIT":    &c.BandwidthLimit,
+		"RATE_LIMIT":         &c.RateLimit,
+		"RATE_BURST":         &c.RateBurst,
+	} {
+		if err := envInt(name, dst); err != nil {
+			return err
+		}
+	}
+	for name, dst := range map[string]*bool{
+		"BLOCK_PRIVATE_HOSTS":     &c.BlockPrivateHosts,
+		"INSECURE_SKIP_VERIFY":    &c.InsecureSkipVerify,
+		"HIDE_USERS":              &c.HideUsers,
+		"ACCESS_LOG":              &c.AccessLog,
+		"ENABLE_PPROF":            &c.EnablePprof,
+		"TRUST_FORWARDED_HEADERS": &c.TrustForwardedHeaders,
+	} {
+		if err := envBool(name, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// envString sets *dst to the named environment variable, if it is set.
+func envString(name string, dst *string) {
+	if value := os.Getenv(name); value != "" {
+		*dst = value
+	}
+}
+
+// envDuration sets *dst to the named environment variable parsed as a
+// duration, if it is set.
+func envDuration(name string, dst *Duration) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", name, value, err)
+	}
+	*dst = Duration(d)
+	return nil
+}
+
+// envInt sets *dst to the named environment variable parsed as an integer,
+// if it is set.
+func envInt(name string, dst *int) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: must be an integer", name, value)
+	}
+	*dst = n
+	return nil
+}
+
+// envBool sets *dst to the named environment variable parsed as a boolean,
+// if it is set.
+func envBool(name string, dst *bool) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: must be true or false", name, value)
+	}
+	*dst = b
+	return nil
+}
+
+// validate checks that the settings are usable.
+func (c Config) validate() error {
+	if n, err := strconv.Atoi(c.Port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid port %q: must be a number between 1 and 65535", c.Port)
+	}
+	if c.BindAddress != "" && net.ParseIP(strings.Trim(c.BindAddress, "[]")) == nil {
+		return fmt.Errorf("invalid bind address %q: must be an IPv4 or IPv6 address", c.BindAddress)
+	}
+	if _, _, err := net.SplitHostPort(c.PprofAddress); c.EnablePprof && err != nil {
+		return fmt.Errorf("invalid pprof address %q: must be host:port, such as 127.0.0.1:6060", c.PprofAddress)
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if (c.AuthUser == "") != (c.AuthPass == "") {
+		return errors.New("AUTH_USER and AUTH_PASS must be set together")
+	}
+	if c.DefaultScheme != "http" && c.DefaultScheme != "https" {
+		return fmt.Errorf("invalid default scheme %q: must be http or https", c.DefaultScheme)
+	}
+	if c.HTTPTimeout <= 0 {
+		return fmt.Errorf("invalid HTTP timeout %s: must be positive", time.Duration(c.HTTPTimeout))
+	}
+	if c.PollInterval < 0 {
+		return fmt.Errorf("invalid background poll interval %s: must not be negative", time.Duration(c.PollInterval))
+	}
+	if c.RequestTimeout <= 0 {
+		return fmt.Errorf("invalid request timeout %s: must be positive", time.Duration(c.RequestTimeout))
+	}
+	if c.MaxResponseBytes < 1 {
+		return fmt.Errorf("invalid max response bytes %d: must be a positive integer", c.MaxResponseBytes)
+	}
+	if c.RetryAttempts < 1 {
+		return fmt.Errorf("invalid retry attempts %d: must be a positive integer", c.RetryAttempts)
+	}
+	if c.MaxSessions < 0 {
+		return fmt.Errorf("invalid max sessions %d: must be a positive integer", c.MaxSessions)
+	}
+	if c.MaxTitleLength < 0 {
+		return fmt.Errorf("invalid max title length %d: must be a positive integer", c.MaxTitleLength)
+	}
+	if c.BandwidthLimit < 0 {
+		return fmt.Errorf("invalid bandwidth limit %d: must be a non-negative integer, or 0 for no limit", c.BandwidthLimit)
+	}
+	if c.RateLimit < 0 {
+		return fmt.Errorf("invalid rate limit %d: must be a positive integer", c.RateLimit)
+	}
+	if c.RateLimit > 0 && c.RateBurst < 1 {
+		return fmt.Errorf("invalid rate burst %d: must be a positive integer", c.RateBurst)
+	}
+	return nil
+}
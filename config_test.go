@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// writeConfig writes a config file with the given contents and returns its
+// path.
+func writeConfig(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	path := writeConfig(t, `{
+		"port": "9000",
+		"tautulli_url": "http://tautulli.lan:8181",
+		"api_key": "from-file",
+		"http_timeout": "5s",
+		"cache_ttl": "30s",
+		"max_sessions": 2,
+		"allowed_hosts": ["tautulli.lan"]
+	}`)
+	t.Setenv("TAUTULLI_API_KEY", "from-env")
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != "9000" || cfg.TautulliURL != "http://tautulli.lan:8181" || cfg.MaxSessions != 2 {
+		t.Errorf("got port %q, URL %q, max_sessions %d from the file", cfg.Port, cfg.TautulliURL, cfg.MaxSessions)
+	}
+	if time.Duration(cfg.HTTPTimeout) != 5*time.Second || time.Duration(cfg.CacheTTL) != 30*time.Second {
+		t.Errorf("got http_timeout %v and cache_ttl %v, want 5s and 30s", time.Duration(cfg.HTTPTimeout), time.Duration(cfg.CacheTTL))
+	}
+	if !slices.Equal(cfg.AllowedHosts, []string{"tautulli.lan"}) {
+		t.Errorf("allowed_hosts = %q", cfg.AllowedHosts)
+	}
+	if cfg.APIKey != "from-env" {
+		t.Errorf("api_key = %q, want the environment to override the file", cfg.APIKey)
+	}
+	if cfg.StaleTTL != defaultConfig().StaleTTL {
+		t.Errorf("stale_ttl = %v, want the default for settings the file leaves out", time.Duration(cfg.StaleTTL))
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	for name, contents := range map[string]string{
+		"unknown setting": `{"prot": "9000"}`,
+		"bad duration":    `{"cache_ttl": "soon"}`,
+		"not JSON":        `port: 9000`,
+	} {
+		if _, err := loadConfig(writeConfig(t, contents)); err == nil {
+			t.Errorf("%s: loadConfig succeeded, want an error", name)
+		}
+	}
+}
+
+func TestExampleConfigKeepsLayoutLimits(t *testing.T) {
+	cfg, err := loadConfig("config.example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream, _ := fakeTautulli(t, activityBody(episodeJSON, movieJSON, episodeJSON))
+	cfg.TautulliURL = upstream.URL
+	cfg.RetryAttempts = 1
+
+	page := decodePage(t, get(newServer(cfg), "/?layout=quadrant"))
+	if len(page.Sessions) != 1 {
+		t.Errorf("got %d sessions in the quadrant layout, want 1", len(page.Sessions))
+	}
+}
+
+func TestValidateCredentialPairs(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*Config)
+		wantErr bool
+	}{
+		{"defaults", func(*Config) {}, false},
+		{"both auth settings", func(c *Config) { c.AuthUser, c.AuthPass = "user", "pass" }, false},
+		{"only AUTH_USER", func(c *Config) { c.AuthUser = "user" }, true},
+		{"only AUTH_PASS", func(c *Config) { c.AuthPass = "pass" }, true},
+		{"only TLS_CERT_FILE", func(c *Config) { c.TLSCertFile = "cert.pem" }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			tt.modify(&cfg)
+			if err := cfg.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// parseAllowedOrigins builds a lookup of browser origins such as
+// "https://dashboard.example.com", ignoring case, blanks and trailing slashes.
+func parseAllowedOrigins(list []string) map[string]bool {
+	origins := make(map[string]bool)
+	for _, origin := range list {
+		origin = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(origin)), "/")
+		if origin != "" {
+			origins[origin] = true
+		}
+	}
+	return origins
+}
+
+// cors wraps a handler to let the configured origins call it from a browser.
+// Preflight requests are answered here, before authentication, since browsers
+// send them without credentials. When no origins are configured, no CORS
+// headers are sent.
+func (s *Server) cors(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || len(s.allowedOrigins) == 0 {
+			next(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		switch {
+		case s.allowedOrigins[strings.ToLower(origin)]:
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		case s.allowedOrigins["*"]:
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		default:
+			next(w, r)
+			return
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next(w, r)
+	}
+}
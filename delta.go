@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// streamCountTTL is how long a stream count is remembered without being
+// requested again before it is forgotten.
+const streamCountTTL = time.Hour
+
+// streamCounts remembers the stream count last returned for each set of
+// servers and filters, so the next response can say whether it went up or
+// down. It is only kept in memory, so it starts over when the service
+// restarts.
+type streamCounts struct {
+	mu     sync.Mutex
+	counts map[string]streamCount
+	pruned time.Time
+}
+
+type streamCount struct {
+	count int
+	seen  time.Time
+}
+
+func newStreamCounts() *streamCounts {
+	return &streamCounts{counts: make(map[string]streamCount)}
+}
+
+// swap stores count under key and returns the count stored before it, if
+// any.
+func (c *streamCounts) swap(key string, count int, now time.Time) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.prune(now)
+	previous, ok := c.counts[key]
+	c.counts[key] = streamCount{count: count, seen: now}
+	return previous.count, ok
+}
+
+// prune drops counts that haven't been requested for streamCountTTL. It runs
+// at most once a minute.
+func (c *streamCounts) prune(now time.Time) {
+	if now.Sub(c.pruned) < time.Minute {
+		return
+	}
+	c.pruned = now
+	for key, entry := range c.counts {
+		if now.Sub(entry.seen) >= streamCountTTL {
+			delete(c.counts, key)
+		}
+	}
+}
+
+// streamCountKey identifies whose stream count a request sees: its servers'
+// credentials plus the filters that change the count.
+func streamCountKey(servers []tautulliServer, query url.Values) string {
+	var b strings.Builder
+	for _, server := range servers {
+		b.WriteString(server.URL + "|" + server.APIKey + ",")
+	}
+	for _, name := range []string{"users", "media_types", "hide_paused"} {
+		b.WriteString("&" + name + "=" + strings.Join(query[name], ","))
+	}
+	return b.String()
+}
+
+// formatDelta returns how the stream count changed since the previous
+// request, like "▲1" or "▼2", or "—" when it is unchanged or there was no
+// previous request.
+func formatDelta(previous, current int, known bool) string {
+	switch {
+	case !known || current == previous:
+		return "—"
+	case current > previous:
+		return "▲" + strconv.Itoa(current-previous)
+	default:
+		return "▼" + strconv.Itoa(previous-current)
+	}
+}
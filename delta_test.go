@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeltaAcrossRequests(t *testing.T) {
+	var body atomic.Value
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body.Load().(string)))
+	}))
+	defer upstream.Close()
+	cfg := testConfig()
+	cfg.TautulliURL = upstream.URL
+	cfg.APIKey = "key"
+	cfg.CacheTTL = 0
+	s := newServer(cfg)
+
+	steps := []struct {
+		body  string
+		query string
+		want  string
+	}{
+		{activityBody(episodeJSON), "", "—"}, // Nothing to compare with yet
+		{activityBody(episodeJSON, movieJSON, trackJSON), "", "▲2"},
+		{activityBody(movieJSON), "", "▼2"},
+		{activityBody(movieJSON), "", "—"},
+		{activityBody(episodeJSON, movieJSON), "?users=bob", "—"}, // Filtered counts are tracked separately
+	}
+	for i, step := range steps {
+		body.Store(step.body)
+		if page := decodePage(t, get(s, "/"+step.query)); page.Delta != step.want {
+			t.Errorf("request %d: delta = %q, want %q", i+1, page.Delta, step.want)
+		}
+	}
+}
+
+func TestStreamCountsArePruned(t *testing.T) {
+	counts := newStreamCounts()
+	start := time.Now()
+	counts.swap("a", 1, start)
+	if previous, ok := counts.swap("a", 2, start.Add(time.Minute)); !ok || previous != 1 {
+		t.Errorf("swap = %d, %t, want 1, true", previous, ok)
+	}
+	if _, ok := counts.swap("a", 3, start.Add(time.Minute+streamCountTTL)); ok {
+		t.Error("a count unused for streamCountTTL was kept")
+	}
+}
+
+func TestStreamCountKey(t *testing.T) {
+	servers := []tautulliServer{{URL: "http://tautulli.lan", APIKey: "key"}}
+	base := streamCountKey(servers, url.Values{})
+	if streamCountKey(servers, url.Values{"layout": {"quadrant"}}) != base {
+		t.Error("the layout changed the stream count key")
+	}
+	if streamCountKey(servers, url.Values{"users": {"alice"}}) == base {
+		t.Error("the users filter didn't change the stream count key")
+	}
+	if streamCountKey([]tautulliServer{{URL: "http://tautulli.lan", APIKey: "other"}}, url.Values{}) == base {
+		t.Error("another API key shares the stream count key")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Depending on the version, Tautulli encodes numeric fields such as
+// stream_count either as JSON numbers or as strings. flexString and flexInt
+// accept both, so a change between versions doesn't break decoding.
+
+// flexString is a string that can also be decoded from a JSON number. null
+// decodes to an empty string.
+type flexString string
+
+func (f *flexString) UnmarshalJSON(b []byte) error {
+	if bytes.Equal(b, []byte("null")) {
+		*f = ""
+		return nil
+	}
+	if len(b) > 0 && b[0] == '"' {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		*f = flexString(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return fmt.Errorf("expected a string or number, got %s", b)
+	}
+	*f = flexString(n.String())
+	return nil
+}
+
+// flexInt is an int that can also be decoded from a numeric JSON string. null
+// and an empty string decode to 0.
+type flexInt int
+
+func (f *flexInt) UnmarshalJSON(b []byte) error {
+	var s flexString
+	if err := s.UnmarshalJSON(b); err != nil {
+		return err
+	}
+	if s == "" {
+		*f = 0
+		return nil
+	}
+	n, err := strconv.ParseFloat(string(s), 64)
+	if err != nil {
+		return fmt.Errorf("expected a number, got %s", b)
+	}
+	*f = flexInt(n)
+	return nil
+}
+
+// UnmarshalJSON decodes a session, accepting numbers as well as strings for
+// its numeric fields.
+func (session *Session) UnmarshalJSON(b []byte) error {
+	type plain Session
+	aux := struct {
+		*plain
+		SessionKey       flexString `json:"session_key"`
+		ParentMediaIndex flexString `json:"parent_media_index"`
+		MediaIndex       flexString `json:"media_index"`
+		Live             flexString `json:"live"`
+		SyncedVersion    flexString `json:"synced_version"`
+		ProgressPercent  flexString `json:"progress_percent"`
+		Duration         flexString `json:"duration"`
+		ViewOffset       flexString `json:"view_offset"`
+		Started          flexString `json:"started"`
+		Bandwidth        flexString `json:"bandwidth"`
+	}{plain: (*plain)(session)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+
+	session.SessionKey = string(aux.SessionKey)
+	session.ParentMediaIndex = string(aux.ParentMediaIndex)
+	session.MediaIndex = string(aux.MediaIndex)
+	session.Live = string(aux.Live)
+	session.SyncedVersion = string(aux.SyncedVersion)
+	session.ProgressPercent = string(aux.ProgressPercent)
+	session.Duration = string(aux.Duration)
+	session.ViewOffset = string(aux.ViewOffset)
+	session.Started = string(aux.Started)
+	session.Bandwidth = string(aux.Bandwidth)
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFlexInt(t *testing.T) {
+	tests := []struct {
+		json    string
+		want    int
+		wantErr bool
+	}{
+		{`3`, 3, false},
+		{`"3"`, 3, false},
+		{`2.0`, 2, false},
+		{`""`, 0, false},
+		{`null`, 0, false},
+		{`"many"`, 0, true},
+		{`true`, 0, true},
+	}
+	for _, tt := range tests {
+		var got flexInt
+		err := json.Unmarshal([]byte(tt.json), &got)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("decoding %s: error = %v, want error %t", tt.json, err, tt.wantErr)
+			continue
+		}
+		if int(got) != tt.want {
+			t.Errorf("decoding %s = %d, want %d", tt.json, got, tt.want)
+		}
+	}
+}
+
+func TestSessionNumbersAsStringsOrNumbers(t *testing.T) {
+	for _, raw := range []string{
+		`{"session_key":"12","progress_percent":"42","duration":"1320000","view_offset":"554400","started":"1714600000","parent_media_index":"2","media_index":"5","live":"0","bandwidth":"8500"}`,
+		`{"session_key":12,"progress_percent":42,"duration":1320000,"view_offset":554400,"started":1714600000,"parent_media_index":2,"media_index":5,"live":0,"bandwidth":8500}`,
+	} {
+		var session Session
+		if err := json.Unmarshal([]byte(raw), &session); err != nil {
+			t.Fatalf("failed to decode %s: %v", raw, err)
+		}
+		want := Session{
+			SessionKey: "12", ProgressPercent: "42", Duration: "1320000", ViewOffset: "554400", Started: "1714600000",
+			ParentMediaIndex: "2", MediaIndex: "5", Live: "0", Bandwidth: "8500",
+		}
+		if session != want {
+			t.Errorf("decoding %s = %+v, want %+v", raw, session, want)
+		}
+	}
+}
+
+func TestStreamCountAsStringOrNumber(t *testing.T) {
+	for _, count := range []string{`2`, `"2"`} {
+		var data TautulliResponse
+		raw := `{"response":{"result":"success","data":{"stream_count":` + count + `,"sessions":[]}}}`
+		if err := json.Unmarshal([]byte(raw), &data); err != nil {
+			t.Fatalf("stream_count %s: %v", count, err)
+		}
+		if data.Response.Data.StreamCount != 2 {
+			t.Errorf("stream_count %s decoded as %d, want 2", count, data.Response.Data.StreamCount)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gzipResponseWriter compresses everything written through it into a buffer,
+// so the compressed response can be sent with its Content-Length.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz     *gzip.Writer
+	buf    bytes.Buffer
+	status int
+}
+
+// WriteHeader holds on to the status until the body has been compressed.
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	return w.gz.Write(b)
+}
+
+// finish sends the status and the compressed body, replacing any
+// Content-Length the handler set for the uncompressed body.
+func (w *gzipResponseWriter) finish() {
+	if err := w.gz.Close(); err != nil {
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(w.buf.Len()))
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	w.ResponseWriter.Write(w.buf.Bytes())
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding allows gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(encoding), ";")
+		if strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			return strings.ReplaceAll(params, " ", "") != "q=0"
+		}
+	}
+	return false
+}
+
+// gzipResponses wraps a handler to gzip its response when the client accepts
+// it.
+func gzipResponses(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) {
+			next(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Encoding", "gzip")
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		gw.gz = gzip.NewWriter(&gw.buf)
+		next(gw, r)
+		gw.finish()
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// handleHealthz reports that the server is running. It never contacts
+// Tautulli, so it is safe to use as a liveness probe.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeStatus(w, http.StatusOK, "ok")
+}
+
+// handleReadyz checks that the configured Tautulli servers are reachable.
+// When no server is configured there is nothing to check.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	client := http.Client{Timeout: 2 * time.Second, Transport: s.client.Transport}
+	for _, tautulliURL := range splitList(s.cfg.TautulliURL) {
+		tautulliURL = withBasePath(normalizeURL(tautulliURL, s.cfg.DefaultScheme), s.cfg.BasePath)
+		req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, tautulliURL, nil)
+		if err != nil {
+			slog.WarnContext(r.Context(), "invalid Tautulli URL for readiness check", "handler", "readyz", "tautulli_url", redactURL(tautulliURL), "error", err)
+			writeStatus(w, http.StatusServiceUnavailable, "unavailable")
+			return
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			slog.WarnContext(r.Context(), "readiness check failed to reach Tautulli", "handler", "readyz", "tautulli_url", redactURL(tautulliURL), "error", redactError(err))
+			writeStatus(w, http.StatusServiceUnavailable, "unavailable")
+			return
+		}
+		resp.Body.Close()
+	}
+
+	writeStatus(w, http.StatusOK, "ok")
+}
+
+// writeStatus writes a small JSON body like {"status":"ok"}.
+func writeStatus(w http.ResponseWriter, code int, status string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": status}); err != nil {
+		slog.Error("failed to encode status response", "error", err)
+	}
+}
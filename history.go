@@ -0,0 +1,108 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// HistoryResponse is the structure of Tautulli's get_history response.
+type HistoryResponse struct {
+	Response struct {
+		Result  string `json:"result"`
+		Message string `json:"message"`
+		Data    struct {
+			Data []HistoryItem `json:"data"`
+		} `json:"data"`
+	} `json:"response"`
+}
+
+// HistoryItem is a single finished play from the Tautulli history.
+type HistoryItem struct {
+	User             string  `json:"user"`
+	Player           string  `json:"player"`
+	GrandparentTitle string  `json:"grandparent_title"`
+	ParentTitle      string  `json:"parent_title"`
+	Title            string  `json:"title"`
+	MediaType        string  `json:"media_type"`
+	Thumb            string  `json:"thumb"`
+	PercentComplete  flexInt `json:"percent_complete"`
+	Stopped          flexInt `json:"stopped"` // Unix timestamp
+}
+
+// session converts a history item into a Session, so it can be enriched and
+// rendered like a current stream.
+func (item HistoryItem) session() Session {
+	return Session{
+		User:             item.User,
+		Player:           item.Player,
+		GrandparentTitle: item.GrandparentTitle,
+		ParentTitle:      item.ParentTitle,
+		Title:            item.Title,
+		MediaType:        item.MediaType,
+		Thumb:            item.Thumb,
+		ProgressPercent:  strconv.Itoa(int(item.PercentComplete)),
+	}
+}
+
+// fetchHistory requests the most recent length plays from Tautulli.
+func (s *Server) fetchHistory(ctx context.Context, tautulliURL, apiKey string, length int) ([]HistoryItem, error) {
+	var data HistoryResponse
+
+	apiURL := fmt.Sprintf("%s/api/v2?apikey=%s&cmd=get_history&length=%d", tautulliURL, apiKey, length)
+	resp, err := s.getWithRetry(ctx, apiURL)
+	if err != nil {
+		return nil, connectError(ctx, err)
+	}
+	defer resp.Body.Close()
+
+	if err := s.decodeResponse(resp, &data); err != nil {
+		return nil, err
+	}
+
+	if data.Response.Result != "success" {
+		err := fmt.Errorf("result %q: %s", data.Response.Result, data.Response.Message)
+		return nil, &fetchError{http.StatusBadGateway, fmt.Sprintf("Tautulli returned an error: %s", data.Response.Message), err}
+	}
+	return data.Response.Data.Data, nil
+}
+
+// recentSessions returns up to length of the most recently finished plays
+// across the given servers, newest first. Servers whose history can't be
+// fetched are skipped, since this only replaces the empty state.
+func (s *Server) recentSessions(ctx context.Context, servers []tautulliServer, length int, keep func(*Session) bool, enrich func(*Session, tautulliServer)) []Session {
+	type recent struct {
+		session Session
+		stopped int64
+	}
+
+	var items []recent
+	for _, server := range servers {
+		history, err := s.fetchHistory(s.restrictHosts(ctx, server), server.URL, server.APIKey, length)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to fetch Tautulli history", "tautulli_url", redactURL(server.URL), "error", err)
+			continue
+		}
+		for _, item := range history {
+			session := item.session()
+			if !keep(&session) {
+				continue
+			}
+			enrich(&session, server)
+			items = append(items, recent{session, int64(item.Stopped)})
+		}
+	}
+
+	slices.SortStableFunc(items, func(a, b recent) int {
+		return cmp.Compare(b.stopped, a.stopped)
+	})
+	sessions := make([]Session, 0, min(len(items), length))
+	for _, item := range items[:min(len(items), length)] {
+		sessions = append(sessions, item.session)
+	}
+	return sessions
+}
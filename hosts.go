@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+)
+
+// parseAllowedHosts builds a lookup of hostnames, ignoring case and blanks.
+func parseAllowedHosts(list []string) map[string]bool {
+	hosts := make(map[string]bool)
+	for _, host := range list {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			hosts[host] = true
+		}
+	}
+	return hosts
+}
+
+// checkServers returns an error if any of the servers in a request is not
+// permitted. Only the operator-configured TAUTULLI_URL is trusted; URLs
+// supplied by the client must pass the host restrictions.
+func (s *Server) checkServers(servers []tautulliServer) error {
+	for _, server := range servers {
+		if server.Trusted {
+			continue
+		}
+		if err := s.checkTautulliHost(server.URL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkTautulliHost returns an error if the given Tautulli URL is not in the
+// allowlist. When no allowlist is configured every host passes here; private
+// and loopback addresses are refused later, when connecting, since only then
+// is it known which address a name resolves to.
+func (s *Server) checkTautulliHost(tautulliURL string) error {
+	u, err := url.Parse(tautulliURL)
+	if err != nil {
+		return fmt.Errorf("invalid Tautulli URL: %w", err)
+	}
+	host := strings.ToLower(u.Hostname())
+
+	if len(s.allowedHosts) > 0 && !s.allowedHosts[host] {
+		return fmt.Errorf("host %q is not in ALLOWED_TAUTULLI_HOSTS", host)
+	}
+	return nil
+}
+
+var (
+	// errHostNotAllowed is returned when a request to a client-supplied
+	// Tautulli URL would connect to a private or loopback address.
+	errHostNotAllowed = errors.New("Tautulli host is not allowed")
+	// errRedirectRefused is returned when a client-supplied Tautulli URL
+	// redirects, since the new URL hasn't been checked.
+	errRedirectRefused = errors.New("redirects are not followed for Tautulli URLs from the request")
+)
+
+// hostPolicy says what a request to a client-supplied Tautulli URL may
+// connect to. Requests without one, for the configured servers, are
+// unrestricted.
+type hostPolicy struct {
+	AllowPrivate bool
+}
+
+type hostPolicyKey struct{}
+
+// restrictHosts returns ctx with the host restrictions for server attached,
+// for the HTTP client to enforce. The configured servers are trusted, so
+// their context is returned unchanged. Private and loopback addresses are
+// allowed for hosts in ALLOWED_TAUTULLI_HOSTS, or for every host when
+// BLOCK_PRIVATE_HOSTS is turned off.
+func (s *Server) restrictHosts(ctx context.Context, server tautulliServer) context.Context {
+	if server.Trusted {
+		return ctx
+	}
+	host := ""
+	if u, err := url.Parse(server.URL); err == nil {
+		host = strings.ToLower(u.Hostname())
+	}
+	policy := hostPolicy{AllowPrivate: !s.cfg.BlockPrivateHosts || s.allowedHosts[host]}
+	return context.WithValue(ctx, hostPolicyKey{}, policy)
+}
+
+// checkDial refuses to connect to private and loopback addresses when the
+// request's host policy doesn't allow them. It is a net.Dialer
+// ControlContext, so it checks the address actually being connected to and
+// can't be fooled by a name that resolves differently the second time.
+func checkDial(ctx context.Context, network, address string, _ syscall.RawConn) error {
+	policy, ok := ctx.Value(hostPolicyKey{}).(hostPolicy)
+	if !ok || policy.AllowPrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errHostNotAllowed, err)
+	}
+	if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+		return fmt.Errorf("%w: %s is a private address", errHostNotAllowed, host)
+	}
+	return nil
+}
+
+// checkRedirect is the HTTP client's CheckRedirect. Client-supplied Tautulli
+// URLs may not redirect, so an allowed host can't send us to one that isn't.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if _, ok := req.Context().Value(hostPolicyKey{}).(hostPolicy); ok {
+		return errRedirectRefused
+	}
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	return nil
+}
+
+// isPrivateIP reports whether ip is a loopback, private, or link-local
+// address.
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified()
+}
+
+// isLocalHost reports whether the host of a URL looks like it is on the local
+// network: a private IP address, or a name such as "localhost", "nas", or
+// "tautulli.local". It doesn't resolve names, so it never blocks.
+func isLocalHost(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if ip := net.ParseIP(host); ip != nil {
+		return isPrivateIP(ip)
+	}
+	if !strings.Contains(host, ".") {
+		return host != ""
+	}
+	for _, suffix := range []string{".local", ".lan", ".home.arpa", ".internal"} {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
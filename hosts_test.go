@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHostRestrictions(t *testing.T) {
+	upstream, _ := fakeTautulli(t, activityBody(episodeJSON))
+	loopback := upstream.URL
+	localhost := strings.Replace(upstream.URL, "127.0.0.1", "localhost", 1)
+
+	tests := []struct {
+		name         string
+		allowedHosts []string
+		allowPrivate bool
+		tautulliURL  string
+		want         int
+	}{
+		{"private address by default", nil, false, loopback, http.StatusForbidden},
+		{"name resolving to a private address", nil, false, localhost, http.StatusForbidden},
+		{"allowed host", []string{"127.0.0.1"}, false, loopback, http.StatusOK},
+		{"host missing from the allowlist", []string{"127.0.0.1"}, false, localhost, http.StatusForbidden},
+		{"private addresses allowed", nil, true, localhost, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.AllowedHosts = tt.allowedHosts
+			cfg.BlockPrivateHosts = !tt.allowPrivate
+
+			rec := get(newServer(cfg), "/?api_key=key&tautulli_url="+tt.tautulliURL)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d; body: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
+
+func TestHostRestrictionsSkipConfiguredServers(t *testing.T) {
+	upstream, _ := fakeTautulli(t, activityBody(episodeJSON))
+	cfg := testConfig()
+	cfg.TautulliURL = upstream.URL
+	cfg.APIKey = "key"
+
+	decodePage(t, get(newServer(cfg), "/"))
+}
+
+func TestRedirectsAreNotFollowed(t *testing.T) {
+	target, requests := fakeTautulli(t, activityBody(episodeJSON))
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, strings.Replace(target.URL, "127.0.0.1", "localhost", 1)+r.URL.RequestURI(), http.StatusFound)
+	}))
+	defer redirector.Close()
+
+	cfg := testConfig()
+	cfg.AllowedHosts = []string{"127.0.0.1"}
+	rec := get(newServer(cfg), "/?api_key=key&tautulli_url="+redirector.URL)
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want 502; body: %s", rec.Code, rec.Body)
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("the redirect was followed %d times", n)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import "strings"
+
+// defaultLang is the language used when the lang parameter is missing or
+// names a language without a catalog.
+const defaultLang = "en"
+
+// catalog maps message names to the text shown for them in one language.
+type catalog map[string]string
+
+// catalogs maps language codes such as "de" to their catalogs.
+type catalogs map[string]catalog
+
+// builtinCatalogs are the languages the service ships with. English must have
+// every message, since the other languages fall back to it for anything they
+// leave out.
+var builtinCatalogs = catalogs{
+	"en": {
+		"updated":           "Updated:",
+		"stale_since":       "Stale since",
+		"over_limit":        "Over limit:",
+		"recently_watched":  "Recently watched",
+		"empty_message":     "Nothing is currently playing.",
+		"page":              "Page %d of %d",
+		"time_remaining":    "%d min left",
+		"started":           "Started",
+		"playing":           "▶ Playing",
+		"paused":            "❚❚ Paused",
+		"buffering":         "… Buffering",
+		"transcode":         "Transcode",
+		"direct_stream":     "Direct Stream",
+		"direct_play":       "Direct Play",
+		"live":              "LIVE",
+		"synced":            "Synced",
+		"idle":              "Idle",
+		"streaming":         "%d streaming",
+		"bandwidth_summary": "Total: %s Mbps (WAN %s / LAN %s)",
+		"hidden_user":       "Someone",
+	},
+	"de": {
+		"updated":           "Aktualisiert:",
+		"stale_since":       "Veraltet seit",
+		"over_limit":        "Über dem Limit:",
+		"recently_watched":  "Zuletzt angesehen",
+		"empty_message":     "Gerade wird nichts abgespielt.",
+		"page":              "Seite %d von %d",
+		"time_remaining":    "noch %d Min.",
+		"started":           "Gestartet",
+		"playing":           "▶ Läuft",
+		"paused":            "❚❚ Pausiert",
+		"buffering":         "… Puffert",
+		"transcode":         "Transkodierung",
+		"direct_stream":     "Direkter Stream",
+		"direct_play":       "Direkte Wiedergabe",
+		"live":              "LIVE",
+		"synced":            "Synchronisiert",
+		"idle":              "Inaktiv",
+		"streaming":         "%d aktiv",
+		"bandwidth_summary": "Gesamt: %s Mbps (WAN %s / LAN %s)",
+		"hidden_user":       "Jemand",
+	},
+}
+
+// labels returns the messages for the lang parameter, layered from English
+// to the base language to the region, so "de-AT" falls back to "de" and
+// unknown languages to English. Translations from the config file take
+// precedence over the built-in ones, so they can fix single messages or add
+// whole languages.
+func (s *Server) labels(lang string) catalog {
+	lang = strings.ToLower(strings.ReplaceAll(lang, "_", "-"))
+	layers := []string{defaultLang}
+	if base, _, ok := strings.Cut(lang, "-"); ok {
+		layers = append(layers, base)
+	}
+	layers = append(layers, lang)
+
+	labels := make(catalog, len(builtinCatalogs[defaultLang]))
+	for _, layer := range layers {
+		for _, source := range []catalogs{builtinCatalogs, s.cfg.Translations} {
+			for key, text := range source[layer] {
+				labels[key] = text
+			}
+		}
+	}
+	return labels
+}
+
+// emptyMessage returns EMPTY_MESSAGE, or the translated default when it hasn't
+// been changed.
+func (s *Server) emptyMessage(labels catalog) string {
+	if s.cfg.EmptyMessage == defaultConfig().EmptyMessage {
+		return labels["empty_message"]
+	}
+	return s.cfg.EmptyMessage
+}
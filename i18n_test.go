@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestActivityInGerman(t *testing.T) {
+	body := strings.Replace(activityBody(movieJSON), `"total_bandwidth":0,"wan_bandwidth":0,"lan_bandwidth":0`, `"total_bandwidth":4000,"wan_bandwidth":4000,"lan_bandwidth":0`, 1)
+	upstream, _ := fakeTautulli(t, body)
+	cfg := testConfig()
+	cfg.TautulliURL = upstream.URL
+	cfg.APIKey = "key"
+
+	page := decodePage(t, get(newServer(cfg), "/?lang=de-AT&hide_users=true"))
+	session := page.Sessions[0]
+	for _, tt := range []struct{ field, got, want string }{
+		{"labels.updated", page.Labels["updated"], "Aktualisiert:"},
+		{"bandwidth_summary", page.BandwidthSummary, "Gesamt: 4 Mbps (WAN 4 / LAN 0)"},
+		{"state_label", session.StateLabel, "❚❚ Pausiert"},
+		{"transcode_label", session.TranscodeLabel, "Transkodierung"},
+		{"time_remaining", session.TimeRemaining, "noch 153 Min."},
+		{"user", session.User, "Jemand"},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.field, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLabelsFallBackToEnglish(t *testing.T) {
+	cfg := testConfig()
+	cfg.Translations = catalogs{"fr": {"updated": "Mis à jour :"}}
+	s := newServer(cfg)
+
+	if got := s.labels("fr")["updated"]; got != "Mis à jour :" {
+		t.Errorf("configured translation: got %q", got)
+	}
+	if got := s.labels("fr")["paused"]; got != "❚❚ Paused" {
+		t.Errorf("message missing from the configured language: got %q, want English", got)
+	}
+	if got := s.labels("xx")["updated"]; got != "Updated:" {
+		t.Errorf("unknown language: got %q, want English", got)
+	}
+	if got := s.emptyMessage(s.labels("de")); got != "Gerade wird nichts abgespielt." {
+		t.Errorf("default empty message in German: got %q", got)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestIDs(t *testing.T) {
+	upstream, _ := fakeTautulli(t, activityBody())
+	s := configuredServer(upstream)
+
+	tests := []struct {
+		name, incoming string
+		echoed         bool
+	}{
+		{"generated", "", false},
+		{"from the client", "trmnl-42", true},
+		{"invalid incoming ID", "bad id\n", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.incoming != "" {
+			req.Header.Set("X-Request-ID", tt.incoming)
+		}
+		rec := httptest.NewRecorder()
+		s.routes().ServeHTTP(rec, req)
+
+		id := rec.Header().Get("X-Request-ID")
+		if id == "" {
+			t.Errorf("%s: no X-Request-ID header", tt.name)
+		}
+		if echoed := id == tt.incoming; echoed != tt.echoed {
+			t.Errorf("%s: X-Request-ID = %q for an incoming %q", tt.name, id, tt.incoming)
+		}
+	}
+}
+
+func TestLogLinesHaveRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(contextHandler{slog.NewJSONHandler(&buf, nil)})
+
+	withRequestIDs(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.InfoContext(r.Context(), "fetching")
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	var line struct {
+		RequestID string `json:"request_id"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+	if len(line.RequestID) != 16 {
+		t.Errorf("request_id = %q, want a 16 character ID", line.RequestID)
+	}
+}
@@ -1,132 +1,843 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/tls"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
 // TautulliResponse defines the structure for the JSON response from the Tautulli API.
 type TautulliResponse struct {
 	Response struct {
-		Data struct {
-			StreamCount string    `json:"stream_count"`
-			Sessions    []Session `json:"sessions"`
+		Result  string `json:"result"`
+		Message string `json:"message"`
+		Data    struct {
+			StreamCount    flexInt   `json:"stream_count"`
+			TotalBandwidth flexInt   `json:"total_bandwidth"` // Kbps
+			WANBandwidth   flexInt   `json:"wan_bandwidth"`   // Kbps
+			LANBandwidth   flexInt   `json:"lan_bandwidth"`   // Kbps
+			Sessions       []Session `json:"sessions"`
 		} `json:"data"`
 	} `json:"response"`
 }
 
 // Session represents a single media stream from the Tautulli API.
 type Session struct {
-	User             string `json:"user"`
-	Player           string `json:"player"`
-	GrandparentTitle string `json:"grandparent_title"`
-	Title            string `json:"title"`
-	MediaType        string `json:"media_type"`
-	Summary          string `json:"summary"`
-	Thumb            string `json:"thumb"`
-	ProgressPercent  string `json:"progress_percent"`
-	PosterURL        string `json:"poster_url"` // This will be constructed in our code
-	Progress         int    `json:"progress"`   // This will be calculated
+	SessionKey                string `json:"session_key"` // Stays the same for the life of a stream
+	User                      string `json:"user"`
+	UserThumb                 string `json:"user_thumb"` // Avatar, usually a plex.tv URL
+	Player                    string `json:"player"`
+	GrandparentTitle          string `json:"grandparent_title"`
+	ParentTitle               string `json:"parent_title"`
+	Title                     string `json:"title"`
+	MediaType                 string `json:"media_type"`
+	Live                      string `json:"live"`               // "1" for live TV
+	ChannelTitle              string `json:"channel_title"`      // Live TV channel
+	ParentMediaIndex          string `json:"parent_media_index"` // Season number, for episodes
+	MediaIndex                string `json:"media_index"`        // Episode number, for episodes
+	Summary                   string `json:"summary"`
+	Thumb                     string `json:"thumb"`
+	ParentThumb               string `json:"parent_thumb"`      // Season or album art
+	GrandparentThumb          string `json:"grandparent_thumb"` // Show or artist art
+	Art                       string `json:"art"`               // Background art
+	ProgressPercent           string `json:"progress_percent"`
+	Duration                  string `json:"duration"`    // Milliseconds
+	ViewOffset                string `json:"view_offset"` // Milliseconds
+	Started                   string `json:"started"`     // Unix timestamp
+	State                     string `json:"state"`       // playing, paused, or buffering
+	TranscodeDecision         string `json:"transcode_decision"`
+	VideoFullResolution       string `json:"video_full_resolution"`
+	StreamVideoFullResolution string `json:"stream_video_full_resolution"`
+	QualityProfile            string `json:"quality_profile"`           // e.g. "Original" or "4 Mbps 720p"
+	SyncedVersion             string `json:"synced_version"`            // "1" when playing a downloaded copy
+	Bandwidth                 string `json:"bandwidth"`                 // Kbps
+	ID                        string `json:"id"`                        // Stable across refreshes, for element IDs in the markup
+	PosterURL                 string `json:"poster_url"`                // This will be constructed in our code
+	AvatarURL                 string `json:"avatar_url,omitempty"`      // UserThumb, made absolute
+	Progress                  int    `json:"progress"`                  // This will be calculated
+	ProgressUnknown           bool   `json:"progress_unknown"`          // Tautulli didn't report the progress, so no bar is shown
+	TimeRemaining             string `json:"time_remaining,omitempty"`  // This will be calculated
+	StartedLabel              string `json:"started_label,omitempty"`   // e.g. "Started 8:15 PM"
+	Timecode                  string `json:"timecode,omitempty"`        // This will be calculated
+	PlayerLabel               string `json:"player_label"`              // Player, truncated to maxPlayerLength
+	EpisodeLabel              string `json:"episode_label,omitempty"`   // e.g. "S02E05", for episodes
+	TranscodeLabel            string `json:"transcode_label,omitempty"` // Friendly form of TranscodeDecision
+	StateLabel                string `json:"state_label,omitempty"`     // Friendly form of State
+	LiveLabel                 string `json:"live_label,omitempty"`      // "LIVE", for live TV
+	SyncedLabel               string `json:"synced_label,omitempty"`    // "Synced", for downloaded copies
+	Resolution                string `json:"resolution,omitempty"`      // e.g. "1080p" or "4K", empty for music
+	BandwidthLabel            string `json:"bandwidth_label,omitempty"` // e.g. "8.5 Mbps"
+	Server                    string `json:"server,omitempty"`          // Host of the Tautulli server, when several are combined
+	Ending                    bool   `json:"ending"`                    // Progress is past endingThreshold
 }
 
 // PageData is the root object for our JSON response.
 type PageData struct {
-	StreamCount int       `json:"stream_count"`
-	Sessions    []Session `json:"sessions"`
-	Timestamp   string    `json:"timestamp"`
+	StreamCount      int       `json:"stream_count"`
+	Delta            string    `json:"delta"`           // Change in stream_count since the last request, e.g. "▲1", "▼1", or "—"
+	TotalBandwidth   int       `json:"total_bandwidth"` // Kbps
+	WANBandwidth     int       `json:"wan_bandwidth"`   // Kbps
+	LANBandwidth     int       `json:"lan_bandwidth"`   // Kbps
+	BandwidthSummary string    `json:"bandwidth_summary,omitempty"`
+	OverLimit        bool      `json:"over_limit"` // WANBandwidth is above the bandwidth_limit
+	Sessions         []Session `json:"sessions"`
+	Theme            string    `json:"theme"`                 // "light" or "dark"
+	EmptyMessage     string    `json:"empty_message"`         // Shown when nothing is playing
+	Recent           []Session `json:"recent,omitempty"`      // Recently watched, shown instead of EmptyMessage
+	Timestamp        string    `json:"timestamp"`             // Formatted for display with timezone and time_format
+	UpdatedAt        string    `json:"updated_at"`            // RFC 3339, for scripts and custom markup
+	Stale            bool      `json:"stale"`                 // Tautulli was unreachable, so this is the last known data
+	StaleSince       string    `json:"stale_since,omitempty"` // When the stale data was fetched
+	Page             int       `json:"page"`
+	Pages            int       `json:"pages"`
+	PageLabel        string    `json:"page_label,omitempty"` // e.g. "Page 2 of 3", when there is more than one page
+	HideUsers        bool      `json:"hide_users"`           // User names have been replaced with the hidden_user label
+	Columns          int       `json:"columns"`              // How many columns sessions are laid out in
+	View             string    `json:"view"`                 // "default", or "compact" for text rows without images
+	ShowPercent      bool      `json:"show_percent"`         // Show the progress percentage next to the bar
+	Labels           catalog   `json:"labels"`               // Messages in the lang parameter's language, for the markup
 }
 
-// httpHandler fetches data from Tautulli and returns it as a JSON object.
-func httpHandler(w http.ResponseWriter, r *http.Request) {
-	// Get Tautulli URL and API Key from query parameters.
-	tautulliURL := r.URL.Query().Get("tautulli_url")
-	apiKey := r.URL.Query().Get("api_key")
+// endingThreshold is the progress percentage past which a stream is
+// considered nearly finished.
+const endingThreshold = 90
 
-	if tautulliURL == "" || apiKey == "" {
-		http.Error(w, "Missing required query parameters: 'tautulli_url' and 'api_key'", http.StatusBadRequest)
-		log.Println("Error: Received request with missing 'tautulli_url' or 'api_key' query parameters.")
-		return
+// timeFormats maps friendly time format presets to Go layouts.
+var timeFormats = map[string]string{
+	"12h": "3:04 PM",
+	"24h": "15:04",
+}
+
+// maxSessionsLimit is the most sessions that can be requested via
+// max_sessions, to keep the layout readable.
+const maxSessionsLimit = 12
+
+// maxColumns is the most columns sessions can be laid out in.
+const maxColumns = 3
+
+// maxPlayerLength is the longest player name shown before it is truncated.
+const maxPlayerLength = 20
+
+// Layout describes how much content a TRMNL layout size can display.
+type Layout struct {
+	MaxSessions  int
+	PosterWidth  int
+	PosterHeight int
+	ShowPercent  bool // Whether the progress percentage is shown by default
+}
+
+// layouts maps the TRMNL layout names to their display limits.
+var layouts = map[string]Layout{
+	"full":            {MaxSessions: 4, PosterWidth: 120, PosterHeight: 180, ShowPercent: true},
+	"half_horizontal": {MaxSessions: 2, PosterWidth: 90, PosterHeight: 135},
+	"half_vertical":   {MaxSessions: 2, PosterWidth: 90, PosterHeight: 135},
+	"quadrant":        {MaxSessions: 1, PosterWidth: 60, PosterHeight: 90},
+}
+
+// Theme holds the colors used for the placeholder poster in a theme.
+type Theme struct {
+	Background string
+	Foreground string
+}
+
+// themes maps the supported theme names to their placeholder colors.
+var themes = map[string]Theme{
+	"light": {Background: "eee", Foreground: "ccc"},
+	"dark":  {Background: "333", Foreground: "666"},
+}
+
+// getLayout returns the layout for the given name, defaulting to "full"
+// when the name is empty or unrecognized.
+func getLayout(name string) Layout {
+	if layout, ok := layouts[name]; ok {
+		return layout
+	}
+	return layouts["full"]
+}
+
+// formatTimeRemaining returns a label like "12 min left" for a stream with the
+// given duration and view offset in milliseconds, using the time_remaining
+// message. It returns an empty string when the duration is unknown.
+func formatTimeRemaining(duration, viewOffset int, labels catalog) string {
+	if duration <= 0 {
+		return ""
 	}
 
-	if !strings.HasPrefix(tautulliURL, "http://") && !strings.HasPrefix(tautulliURL, "https://") {
-		tautulliURL = "https://" + tautulliURL
+	remaining := duration - viewOffset
+	if remaining < 0 {
+		remaining = 0
 	}
+	minutes := (remaining + 59999) / 60000
+	return fmt.Sprintf(labels["time_remaining"], minutes)
+}
 
-	// 1. Construct the Tautulli API URL.
-	apiURL := fmt.Sprintf("%s/api/v2?apikey=%s&cmd=get_activity", tautulliURL, apiKey)
+// truncate shortens s to at most n characters, ending it with an ellipsis
+// when anything was cut.
+func truncate(s string, n int) string {
+	runes := []rune(strings.TrimSpace(s))
+	if len(runes) <= n {
+		return string(runes)
+	}
+	return strings.TrimSpace(string(runes[:n-1])) + "…"
+}
 
-	// 2. Make the request to Tautulli.
-	client := http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Get(apiURL)
+// episodeLabel returns a label like "S02E05" for an episode, or an empty
+// string for other media or when the numbers are unknown.
+func episodeLabel(session *Session) string {
+	if session.MediaType != "episode" {
+		return ""
+	}
+	season, err := strconv.Atoi(session.ParentMediaIndex)
 	if err != nil {
-		http.Error(w, "Failed to connect to Tautulli", http.StatusInternalServerError)
-		log.Printf("Error connecting to Tautulli: %v", err)
+		return ""
+	}
+	episode, err := strconv.Atoi(session.MediaIndex)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("S%02dE%02d", season, episode)
+}
+
+// formatClock formats a length of time in milliseconds as "H:MM:SS" when it is
+// an hour or more, and as "M:SS" otherwise.
+func formatClock(ms int) string {
+	seconds := ms / 1000
+	hours, minutes := seconds/3600, seconds/60%60
+	seconds %= 60
+	if hours > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", hours, minutes, seconds)
+	}
+	return fmt.Sprintf("%d:%02d", minutes, seconds)
+}
+
+// formatTimecode returns a label like "12:34 / 45:10" for a stream with the
+// given duration and view offset in milliseconds. It returns an empty string
+// when the duration is unknown.
+func formatTimecode(duration, viewOffset int) string {
+	if duration <= 0 {
+		return ""
+	}
+	viewOffset = max(0, min(viewOffset, duration))
+	return formatClock(viewOffset) + " / " + formatClock(duration)
+}
+
+// parseMaxSessions validates a max_sessions value, which must be a positive
+// integer. Values above maxSessionsLimit are capped.
+func parseMaxSessions(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("max_sessions must be a positive integer, got %q", value)
+	}
+	if n > maxSessionsLimit {
+		n = maxSessionsLimit
+	}
+	return n, nil
+}
+
+// parseColumns validates a columns value, which must be between 1 and
+// maxColumns. An empty value means a single column.
+func parseColumns(value string) (int, error) {
+	if value == "" {
+		return 1, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > maxColumns {
+		return 0, fmt.Errorf("columns must be a number between 1 and %d, got %q", maxColumns, value)
+	}
+	return n, nil
+}
+
+// parsePosterSize overrides the layout's poster dimensions with the
+// poster_width and poster_height parameters, when they are given.
+func parsePosterSize(query url.Values, layout *Layout) error {
+	for name, dst := range map[string]*int{"poster_width": &layout.PosterWidth, "poster_height": &layout.PosterHeight} {
+		if value := query.Get(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > maxPlaceholderSize {
+				return fmt.Errorf("%s must be a number between 1 and %d, got %q", name, maxPlaceholderSize, value)
+			}
+			*dst = n
+		}
+	}
+	return nil
+}
+
+// parseView returns the session view named by a view value: "compact", or
+// "default" for anything else.
+func parseView(value string) string {
+	if value == "compact" {
+		return "compact"
+	}
+	return "default"
+}
+
+// requestTimeFormat returns the time format for a request: the time_format
+// parameter, or TIME_FORMAT when it isn't given.
+func (s *Server) requestTimeFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("time_format"); format != "" {
+		return format
+	}
+	return s.cfg.TimeFormat
+}
+
+// formatTimestamp formats t in the named time zone using either a preset from
+// timeFormats or a Go layout string. An empty zone means the server's local
+// time; an unknown zone falls back to UTC.
+func formatTimestamp(t time.Time, zone, format string) string {
+	if zone != "" {
+		loc, err := time.LoadLocation(zone)
+		if err != nil {
+			slog.Warn("unknown time zone, using UTC", "timezone", zone, "error", err)
+			loc = time.UTC
+		}
+		t = t.In(loc)
+	}
+	if layout, ok := timeFormats[format]; ok {
+		format = layout
+	}
+	return t.Format(format)
+}
+
+// formatMbps converts a bandwidth in Kbps to Mbps with at most one decimal.
+func formatMbps(kbps int) string {
+	return strings.TrimSuffix(strconv.FormatFloat(float64(kbps)/1000, 'f', 1, 64), ".0")
+}
+
+// bandwidthLabel converts a session's bandwidth in Kbps, as a string, to a
+// label like "8.5 Mbps". It returns an empty string when the bandwidth is zero
+// or can't be parsed.
+func bandwidthLabel(kbps string) string {
+	n, err := strconv.Atoi(kbps)
+	if err != nil || n <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.1f Mbps", float64(n)/1000)
+}
+
+// formatBandwidthSummary returns a line like "Total: 24 Mbps (WAN 12 / LAN 12)"
+// using the bandwidth_summary message, or an empty string when no bandwidth is
+// in use.
+func formatBandwidthSummary(total, wan, lan int, labels catalog) string {
+	if total <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(labels["bandwidth_summary"], formatMbps(total), formatMbps(wan), formatMbps(lan))
+}
+
+// transcodeLabel converts Tautulli's transcode_decision into display text.
+// Unknown values are passed through unchanged.
+func transcodeLabel(decision string, labels catalog) string {
+	switch strings.ToLower(decision) {
+	case "":
+		return ""
+	case "transcode":
+		return labels["transcode"]
+	case "copy":
+		return labels["direct_stream"]
+	case "direct play":
+		return labels["direct_play"]
+	default:
+		return decision
+	}
+}
+
+// stateLabel converts Tautulli's playback state into display text. Unknown
+// values are passed through unchanged.
+func stateLabel(state string, labels catalog) string {
+	switch strings.ToLower(state) {
+	case "":
+		return ""
+	case "playing", "paused", "buffering":
+		return labels[strings.ToLower(state)]
+	default:
+		return state
+	}
+}
+
+// posterThumb returns the image to use as a session's poster: its own
+// thumbnail, or else the season or album's, the show or artist's, or the
+// background art, whichever is set first. Live TV and music often only have
+// some of these.
+func posterThumb(session *Session) string {
+	for _, thumb := range []string{session.Thumb, session.ParentThumb, session.GrandparentThumb, session.Art} {
+		if thumb != "" {
+			return thumb
+		}
+	}
+	return ""
+}
+
+// avatarSize is the width and height avatars are requested at, in pixels.
+const avatarSize = 40
+
+// imageURL returns the URL that loads a Plex image through Tautulli at the
+// given size. The URL has to carry the API key, so it returns an empty
+// string when the key is TAUTULLI_API_KEY, which must not be exposed, or when
+// there is no image.
+func imageURL(thumb string, server tautulliServer, width, height int) string {
+	if thumb == "" || server.SecretKey {
+		return ""
+	}
+	return fmt.Sprintf("%s/api/v2?apikey=%s&cmd=pms_image_proxy&img=%s&width=%d&height=%d", server.URL, server.APIKey, url.QueryEscape(thumb), width, height)
+}
+
+// avatarURL returns the URL of a user's avatar. Tautulli usually reports
+// these as plex.tv URLs, which are used as they are; a Plex library path is
+// loaded through Tautulli like posters. It returns an empty string when the
+// user has no avatar, or when loading it would expose TAUTULLI_API_KEY.
+func avatarURL(thumb string, server tautulliServer) string {
+	if thumb == "" || hasScheme(thumb) {
+		return thumb
+	}
+	return imageURL(thumb, server, avatarSize, avatarSize)
+}
+
+// isLive reports whether a session is live TV. Tautulli marks these with
+// live set to 1, or with the "live" media type.
+func isLive(session *Session) bool {
+	return session.Live == "1" || session.MediaType == "live"
+}
+
+// resolutionLabel returns the video resolution being streamed, falling back
+// to the source resolution. Music has no resolution.
+func resolutionLabel(session *Session) string {
+	if session.MediaType == "track" {
+		return ""
+	}
+	resolution := session.StreamVideoFullResolution
+	if resolution == "" {
+		resolution = session.VideoFullResolution
+	}
+	if strings.EqualFold(resolution, "4k") {
+		return "4K"
+	}
+	return resolution
+}
+
+// hasScheme reports whether a Tautulli URL starts with http:// or https://.
+func hasScheme(tautulliURL string) bool {
+	return strings.HasPrefix(tautulliURL, "http://") || strings.HasPrefix(tautulliURL, "https://")
+}
+
+// normalizeURL prefixes a Tautulli URL with scheme if it has none.
+func normalizeURL(tautulliURL, scheme string) string {
+	if !hasScheme(tautulliURL) {
+		return scheme + "://" + tautulliURL
+	}
+	return tautulliURL
+}
+
+// withBasePath strips trailing slashes from a Tautulli URL and, when the URL
+// has no path of its own, appends basePath. This supports servers behind a
+// reverse proxy at a subpath such as /tautulli.
+func withBasePath(tautulliURL, basePath string) string {
+	tautulliURL = strings.TrimRight(tautulliURL, "/")
+	basePath = strings.Trim(basePath, "/")
+	if basePath == "" {
+		return tautulliURL
+	}
+	if u, err := url.Parse(tautulliURL); err != nil || u.Path != "" {
+		return tautulliURL
+	}
+	return tautulliURL + "/" + basePath
+}
+
+// renderOptions are the per-request settings that affect how sessions are
+// presented.
+type renderOptions struct {
+	Layout         Layout
+	Theme          string
+	Placeholder    string // URL template for sessions without a thumbnail
+	MaxTitleLength int    // Titles are truncated to this many characters; 0 disables
+	Timezone       string // For times shown on sessions
+	TimeFormat     string
+	Compact        bool    // Text only, so no poster or avatar URLs are built
+	Labels         catalog // Messages in the requested language
+}
+
+// enrichSession fills in the fields we calculate for a session fetched from
+// the given server.
+func enrichSession(session *Session, server tautulliServer, opts renderOptions) {
+	layout := opts.Layout
+	session.ID = sessionID(session, server)
+	// The compact view is text only, so it gets no image URLs.
+	if !opts.Compact {
+		session.PosterURL = imageURL(posterThumb(session), server, layout.PosterWidth, layout.PosterHeight)
+		if session.PosterURL == "" {
+			session.PosterURL = placeholderURL(opts.Placeholder, layout, themes[opts.Theme])
+		}
+		session.AvatarURL = avatarURL(session.UserThumb, server)
+	}
+
+	if opts.MaxTitleLength > 0 {
+		session.Title = truncate(session.Title, opts.MaxTitleLength)
+		session.ParentTitle = truncate(session.ParentTitle, opts.MaxTitleLength)
+		session.GrandparentTitle = truncate(session.GrandparentTitle, opts.MaxTitleLength)
+	}
+
+	duration, _ := strconv.Atoi(session.Duration)
+	viewOffset, _ := strconv.Atoi(session.ViewOffset)
+	if progress, err := strconv.Atoi(session.ProgressPercent); err == nil {
+		session.Progress = progress
+	} else if duration > 0 {
+		session.Progress = min(100, max(0, viewOffset*100/duration))
+	} else {
+		session.ProgressUnknown = true
+	}
+	session.Ending = session.Progress > endingThreshold
+
+	session.TimeRemaining = formatTimeRemaining(duration, viewOffset, opts.Labels)
+	session.Timecode = formatTimecode(duration, viewOffset)
+	if isLive(session) {
+		// Live TV has no fixed duration, so there is no progress to show.
+		session.LiveLabel = opts.Labels["live"]
+		session.Progress, session.ProgressUnknown, session.Ending = 0, true, false
+		session.TimeRemaining, session.Timecode = "", ""
+	}
+	if started, _ := strconv.ParseInt(session.Started, 10, 64); started > 0 {
+		session.StartedLabel = opts.Labels["started"] + " " + formatTimestamp(time.Unix(started, 0), opts.Timezone, opts.TimeFormat)
+	}
+	session.PlayerLabel = truncate(session.Player, maxPlayerLength)
+	session.EpisodeLabel = episodeLabel(session)
+	session.TranscodeLabel = transcodeLabel(session.TranscodeDecision, opts.Labels)
+	session.StateLabel = stateLabel(session.State, opts.Labels)
+	if session.SyncedVersion == "1" {
+		session.SyncedLabel = opts.Labels["synced"]
+	}
+	session.Resolution = resolutionLabel(session)
+	session.BandwidthLabel = bandwidthLabel(session.Bandwidth)
+}
+
+// handleActivity fetches data from Tautulli and returns it as a JSON object.
+func (s *Server) handleActivity(w http.ResponseWriter, r *http.Request) {
+	// Get the Tautulli URLs and API keys from the query parameters, falling
+	// back to the configured defaults.
+	servers, err := s.requestServers(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "invalid Tautulli credentials", "handler", "activity", "error", err)
+		return
+	}
+
+	layout := getLayout(r.URL.Query().Get("layout"))
+	maxSessions := layout.MaxSessions
+	if s.cfg.MaxSessions > 0 {
+		maxSessions = s.cfg.MaxSessions
+	}
+	if value := r.URL.Query().Get("max_sessions"); value != "" {
+		n, err := parseMaxSessions(value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			slog.WarnContext(r.Context(), "invalid max_sessions", "handler", "activity", "error", err)
+			return
+		}
+		maxSessions = n
+	}
+
+	sortKey := r.URL.Query().Get("sort")
+	if sortKey == "" {
+		sortKey = defaultSort
+	}
+	if !validSort(sortKey) {
+		http.Error(w, fmt.Sprintf("Unsupported sort %q", sortKey), http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "unsupported sort", "handler", "activity", "sort", sortKey)
 		return
 	}
-	defer resp.Body.Close()
 
-	// 3. Decode the JSON response.
-	var tautulliData TautulliResponse
-	if err := json.NewDecoder(resp.Body).Decode(&tautulliData); err != nil {
-		http.Error(w, "Failed to parse Tautulli response", http.StatusInternalServerError)
-		log.Printf("Error parsing JSON from Tautulli: %v", err)
+	theme := r.URL.Query().Get("theme")
+	if _, ok := themes[theme]; !ok {
+		theme = "light"
+	}
+
+	columns, err := parseColumns(r.URL.Query().Get("columns"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "invalid columns", "handler", "activity", "error", err)
 		return
 	}
+	view := parseView(r.URL.Query().Get("view"))
+	if err := parsePosterSize(r.URL.Query(), &layout); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "invalid poster size", "handler", "activity", "error", err)
+		return
+	}
+	showPercent := layout.ShowPercent
+	if value := r.URL.Query().Get("show_percent"); value != "" {
+		showPercent, _ = strconv.ParseBool(value)
+	}
 
-	// 4. Convert stream_count to an integer.
-	streamCount, err := strconv.Atoi(tautulliData.Response.Data.StreamCount)
+	labels := s.labels(r.URL.Query().Get("lang"))
+	emptyMessage := r.URL.Query().Get("empty_message")
+	if emptyMessage == "" {
+		emptyMessage = s.emptyMessage(labels)
+	}
+
+	page, err := parsePage(r.URL.Query().Get("page"), r.URL.Query().Get("page_interval"), time.Now())
 	if err != nil {
-		streamCount = 0
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "invalid page", "handler", "activity", "error", err)
+		return
 	}
 
-	// Limit to a maximum of 4 sessions for the display
-	sessions := tautulliData.Response.Data.Sessions
-	if len(sessions) > 4 {
-		sessions = sessions[:4]
+	history, _ := strconv.ParseBool(r.URL.Query().Get("history"))
+
+	hide := s.cfg.HideUsers
+	if value := r.URL.Query().Get("hide_users"); value != "" {
+		hide, _ = strconv.ParseBool(value)
 	}
 
-	// 5. Construct full poster URLs and calculate progress for each session.
-	for i := range sessions {
-		session := &sessions[i]
-		if session.Thumb != "" {
-			// **MODIFIED:** Create the full, absolute URL for the poster.
-			encodedThumb := url.QueryEscape(session.Thumb)
-			session.PosterURL = fmt.Sprintf("%s/api/v2?apikey=%s&cmd=pms_image_proxy&img=%s", tautulliURL, apiKey, encodedThumb)
-		} else {
-			session.PosterURL = "https://placehold.co/120x180/eee/ccc?text=No+Art"
+	bandwidthLimit := s.cfg.BandwidthLimit
+	if value := r.URL.Query().Get("bandwidth_limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			http.Error(w, "bandwidth_limit must be a non-negative number of Mbps, or 0 for no limit", http.StatusBadRequest)
+			slog.WarnContext(r.Context(), "invalid bandwidth_limit", "handler", "activity", "value", value)
+			return
+		}
+		bandwidthLimit = n
+	}
+
+	timezone := r.URL.Query().Get("timezone")
+	timeFormat := s.requestTimeFormat(r)
+
+	opts := renderOptions{
+		Layout:         layout,
+		Theme:          theme,
+		Placeholder:    s.requestPlaceholder(r),
+		MaxTitleLength: s.cfg.MaxTitleLength,
+		Timezone:       timezone,
+		TimeFormat:     timeFormat,
+		Compact:        view == "compact",
+		Labels:         labels,
+	}
+
+	if err := s.checkServers(servers); err != nil {
+		http.Error(w, "Tautulli host is not allowed", http.StatusForbidden)
+		slog.WarnContext(r.Context(), "rejected Tautulli URL", "handler", "activity", "error", err)
+		return
+	}
+
+	// 1. Fetch the activity from every server at once. If the client goes away,
+	// the requests to Tautulli are cancelled too.
+	results := s.getActivities(r.Context(), servers)
+
+	// 2. Combine the servers that responded. Only fail if none did.
+	var (
+		sessions       []Session
+		streamCount    int
+		totalBandwidth int
+		wanBandwidth   int
+		lanBandwidth   int
+		staleSince     time.Time
+		fetchErr       *fetchError
+		responded      []tautulliServer
+	)
+	for _, result := range results {
+		if result.Err != nil {
+			if fetchErr == nil {
+				fetchErr = result.Err
+			}
+			continue
+		}
+		responded = append(responded, result.Server)
+
+		data := result.Data.Response.Data
+		streamCount += int(data.StreamCount)
+		totalBandwidth += int(data.TotalBandwidth)
+		wanBandwidth += int(data.WANBandwidth)
+		lanBandwidth += int(data.LANBandwidth)
+
+		// 3. Construct full poster URLs and calculate progress for each session.
+		// Ranging copies each session, so the cached response isn't modified.
+		for _, session := range data.Sessions {
+			enrichSession(&session, result.Server, opts)
+			if len(servers) > 1 {
+				session.Server = result.Server.Label
+			}
+			sessions = append(sessions, session)
 		}
 
-		if progress, err := strconv.Atoi(session.ProgressPercent); err == nil {
-			session.Progress = progress
+		if !result.StaleSince.IsZero() && (staleSince.IsZero() || result.StaleSince.Before(staleSince)) {
+			staleSince = result.StaleSince
 		}
 	}
+	if len(responded) == 0 {
+		http.Error(w, fetchErr.Message, fetchErr.Status)
+		return
+	}
+
+	// Tautulli can list a stream twice while its transcoder restarts.
+	deduped := dedupeSessions(sessions)
+	streamCount -= len(sessions) - len(deduped)
+	sessions = deduped
+
+	// 4. Keep only the requested users' and media types' sessions. The stream
+	// count then only includes those streams, so the empty state shows when
+	// none are playing.
+	var filters []func(*Session) bool
+	if users := splitList(r.URL.Query()["users"]...); len(users) > 0 {
+		filters = append(filters, byUser(users))
+	}
+	if types := knownMediaTypes(splitList(r.URL.Query()["media_types"]...)); len(types) > 0 {
+		filters = append(filters, byMediaType(types))
+	}
+	if hidePaused, _ := strconv.ParseBool(r.URL.Query().Get("hide_paused")); hidePaused {
+		filters = append(filters, notPaused)
+	}
+	if len(filters) > 0 {
+		sessions = filterSessions(sessions, allOf(filters...))
+		streamCount = len(sessions)
+	}
+
+	// 5. Sort the sessions so the same streams are shown between refreshes,
+	// then show the requested page of what fits in the layout.
+	if err := sortSessions(sessions, sortKey); err != nil {
+		http.Error(w, "Failed to sort sessions", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "failed to sort sessions", "handler", "activity", "error", err)
+		return
+	}
+	sessions, page, pages := paginate(sessions, maxSessions, page)
+	if sessions == nil {
+		// Tautulli sends null when nothing is playing, and filtering can
+		// leave nothing too; always send a list so markup can rely on it.
+		sessions = []Session{}
+	}
+	now := time.Now()
 
 	// 6. Prepare data for the final JSON response.
+	previous, known := s.counts.swap(streamCountKey(servers, r.URL.Query()), streamCount, now)
 	pageData := PageData{
-		StreamCount: streamCount,
-		Sessions:    sessions,
-		Timestamp:   time.Now().Format("3:04 PM"),
+		StreamCount:      streamCount,
+		Delta:            formatDelta(previous, streamCount, known),
+		TotalBandwidth:   totalBandwidth,
+		WANBandwidth:     wanBandwidth,
+		LANBandwidth:     lanBandwidth,
+		BandwidthSummary: formatBandwidthSummary(totalBandwidth, wanBandwidth, lanBandwidth, labels),
+		OverLimit:        bandwidthLimit > 0 && wanBandwidth > bandwidthLimit*1000,
+		Page:             page,
+		Pages:            pages,
+		Sessions:         sessions,
+		Theme:            theme,
+		Columns:          columns,
+		View:             view,
+		ShowPercent:      showPercent,
+		EmptyMessage:     emptyMessage,
+		Labels:           labels,
+		Timestamp:        formatTimestamp(now, timezone, timeFormat),
+		UpdatedAt:        now.UTC().Format(time.RFC3339),
 	}
-
-	// 7. Set the content type and encode the response as JSON.
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(pageData); err != nil {
-		log.Printf("Error encoding JSON response: %v", err)
+	if pages > 1 {
+		pageData.PageLabel = fmt.Sprintf(labels["page"], page, pages)
+	}
+	if history && streamCount == 0 {
+		pageData.Recent = s.recentSessions(r.Context(), responded, maxSessions, allOf(filters...), func(session *Session, server tautulliServer) {
+			enrichSession(session, server, opts)
+			if len(servers) > 1 {
+				session.Server = server.Label
+			}
+		})
 	}
+	if !staleSince.IsZero() {
+		pageData.Stale = true
+		pageData.StaleSince = formatTimestamp(staleSince, timezone, timeFormat)
+	}
+	if hide {
+		hideUsers(pageData.Sessions, labels["hidden_user"])
+		hideUsers(pageData.Recent, labels["hidden_user"])
+		pageData.HideUsers = true
+	}
+
+	// 7. Encode the response as JSON.
+	writeJSON(w, r, "activity", pageData)
 }
 
 func main() {
-	http.HandleFunc("/", httpHandler)
+	logger, err := newLogger(os.Getenv("LOG_LEVEL"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid LOG_LEVEL: %v\n", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
 
-	port := "8080"
-	log.Printf("Starting Tautulli TRMNL plugin server on port %s", port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	configPath := flag.String("config", "", "path to a JSON config file")
+	portFlag := flag.String("port", "", "port to listen on (overrides PORT and the config file)")
+	versionFlag := flag.Bool("version", false, "print the version and exit")
+	flag.Parse()
+
+	if *versionFlag {
+		fmt.Println(versionString())
+		return
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		fatal("failed to load configuration", "error", err)
+	}
+	if *portFlag != "" {
+		cfg.Port = *portFlag
+	}
+	if err := cfg.validate(); err != nil {
+		fatal("invalid configuration", "error", err)
+	}
+
+	port := cfg.Port
+	shutdownTimeout := time.Duration(cfg.ShutdownTimeout)
+	s := newServer(cfg)
+	addr := net.JoinHostPort(strings.Trim(cfg.BindAddress, "[]"), port)
+	server := s.httpServer(addr)
+	if cfg.InsecureSkipVerify {
+		slog.Warn("TLS certificate verification for Tautulli is disabled")
+	}
+	if cfg.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			fatal("failed to load TLS certificate", "cert_file", cfg.TLSCertFile, "key_file", cfg.TLSKeyFile, "error", err)
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if interval := time.Duration(cfg.PollInterval); interval > 0 {
+		go s.pollActivity(ctx, interval)
+	}
+	if cfg.EnablePprof {
+		go servePprof(cfg.PprofAddress)
+	}
+
+	go func() {
+		slog.Info("starting Tautulli TRMNL plugin server", "address", addr, "tls", server.TLSConfig != nil, "version", version, "commit", commit)
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			fatal("failed to start server", "error", err)
+		}
+	}()
+
+	<-ctx.Done()
+	slog.Info("shutting down, waiting for in-flight requests", "timeout", shutdownTimeout.String())
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("error during shutdown", "error", err)
+		return
 	}
+	slog.Info("server stopped")
 }
@@ -0,0 +1,867 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	episodeJSON = `{"session_key":"1","user":"alice","player":"Living Room TV","grandparent_title":"The Office","parent_title":"Season 2","title":"The Dundies","media_type":"episode","thumb":"/library/metadata/1/thumb/1","progress_percent":"42","duration":"1320000","view_offset":"554400","transcode_decision":"direct play","state":"playing","parent_media_index":"2","media_index":"1"}`
+	movieJSON   = `{"session_key":"2","user":"bob","player":"iPad","title":"Heat","year":"1995","media_type":"movie","thumb":"/library/metadata/2/thumb/1","progress_percent":"10","duration":"10200000","view_offset":"1020000","transcode_decision":"transcode","state":"paused"}`
+)
+
+// activityBody returns a get_activity response listing the given sessions,
+// each written as JSON.
+func activityBody(sessions ...string) string {
+	return `{"response":{"result":"success","message":null,"data":{"stream_count":"` + strconv.Itoa(len(sessions)) +
+		`","total_bandwidth":0,"wan_bandwidth":0,"lan_bandwidth":0,"sessions":[` + strings.Join(sessions, ",") + `]}}}`
+}
+
+// fakeTautulli starts a stand-in for Tautulli that answers every request with
+// body, and counts the requests it gets.
+func fakeTautulli(t *testing.T, body string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream, &requests
+}
+
+// testConfig returns the default settings with retries turned off, so
+// failures show up quickly.
+func testConfig() Config {
+	cfg := defaultConfig()
+	cfg.RetryAttempts = 1
+	return cfg
+}
+
+// get sends a GET request for target through all of s's routes.
+func get(s *Server, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+// decodePage decodes an activity response, failing the test unless it is a
+// 200 OK.
+func decodePage(t *testing.T, rec *httptest.ResponseRecorder) PageData {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200; body: %s", rec.Code, rec.Body)
+	}
+	var page PageData
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return page
+}
+
+func TestActivityFromEnvironment(t *testing.T) {
+	upstream, requests := fakeTautulli(t, activityBody(episodeJSON))
+	t.Setenv("TAUTULLI_URL", upstream.URL)
+	t.Setenv("TAUTULLI_API_KEY", "secret")
+	cfg, err := loadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.RetryAttempts = 1
+
+	page := decodePage(t, get(newServer(cfg), "/"))
+	if page.StreamCount != 1 || len(page.Sessions) != 1 {
+		t.Errorf("got %d streams and %d sessions, want 1 of each", page.StreamCount, len(page.Sessions))
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("Tautulli got %d requests, want 1", n)
+	}
+}
+
+func TestRequestURLNeedsItsOwnAPIKey(t *testing.T) {
+	attacker, requests := fakeTautulli(t, activityBody())
+	cfg := testConfig()
+	cfg.TautulliURL = "http://tautulli.invalid"
+	cfg.APIKey = "secret"
+	s := newServer(cfg)
+
+	for _, path := range []string{"/", "/summary"} {
+		rec := get(s, path+"?tautulli_url="+attacker.URL)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", path, rec.Code)
+		}
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("the configured API key was sent to a URL from the request %d times", n)
+	}
+}
+
+func TestHandleActivity(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantCount  int
+		wantTitles []string
+	}{
+		{"no streams", activityBody(), http.StatusOK, 0, []string{}},
+		{"one episode", activityBody(episodeJSON), http.StatusOK, 1, []string{"The Dundies"}},
+		{"one movie", activityBody(movieJSON), http.StatusOK, 1, []string{"Heat"}},
+		{
+			"more sessions than fit",
+			activityBody(
+				`{"session_key":"1","title":"One","progress_percent":"50"}`,
+				`{"session_key":"2","title":"Two","progress_percent":"40"}`,
+				`{"session_key":"3","title":"Three","progress_percent":"30"}`,
+				`{"session_key":"4","title":"Four","progress_percent":"20"}`,
+				`{"session_key":"5","title":"Five","progress_percent":"10"}`,
+			),
+			http.StatusOK, 5, []string{"One", "Two", "Three", "Four"},
+		},
+		{"malformed JSON", `{"response":`, http.StatusInternalServerError, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream, _ := fakeTautulli(t, tt.body)
+			cfg := testConfig()
+			cfg.TautulliURL = upstream.URL
+			cfg.APIKey = "key"
+
+			rec := get(newServer(cfg), "/")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			page := decodePage(t, rec)
+			if page.StreamCount != tt.wantCount {
+				t.Errorf("stream_count = %d, want %d", page.StreamCount, tt.wantCount)
+			}
+			if got := titles(page.Sessions); !slices.Equal(got, tt.wantTitles) {
+				t.Errorf("sessions = %q, want %q", got, tt.wantTitles)
+			}
+		})
+	}
+}
+
+// numberedSessions returns n distinct sessions as JSON, with progress
+// decreasing so they keep their order under the default sort.
+func numberedSessions(n int) []string {
+	sessions := make([]string, n)
+	for i := range sessions {
+		sessions[i] = `{"session_key":"` + strconv.Itoa(i+1) + `","title":"Session ` + strconv.Itoa(i+1) + `","progress_percent":"` + strconv.Itoa(90-i) + `"}`
+	}
+	return sessions
+}
+
+// configuredServer returns a Server whose TAUTULLI_URL is upstream.
+func configuredServer(upstream *httptest.Server) *Server {
+	cfg := testConfig()
+	cfg.TautulliURL = upstream.URL
+	cfg.APIKey = "key"
+	return newServer(cfg)
+}
+
+func TestLayoutSessionCaps(t *testing.T) {
+	upstream, _ := fakeTautulli(t, activityBody(numberedSessions(5)...))
+	s := configuredServer(upstream)
+
+	tests := []struct {
+		layout string
+		want   int
+	}{
+		{"", 4},
+		{"full", 4},
+		{"half_horizontal", 2},
+		{"half_vertical", 2},
+		{"quadrant", 1},
+		{"poster", 4}, // Unknown layouts fall back to full
+	}
+	for _, tt := range tests {
+		page := decodePage(t, get(s, "/?layout="+tt.layout))
+		if len(page.Sessions) != tt.want {
+			t.Errorf("layout %q: got %d sessions, want %d", tt.layout, len(page.Sessions), tt.want)
+		}
+		if page.StreamCount != 5 {
+			t.Errorf("layout %q: stream_count = %d, want all 5", tt.layout, page.StreamCount)
+		}
+	}
+}
+
+func TestFormatTimestamp(t *testing.T) {
+	at := time.Date(2024, 5, 1, 19, 30, 0, 0, time.UTC)
+	tests := []struct {
+		zone, format, want string
+	}{
+		{"UTC", "12h", "7:30 PM"},
+		{"UTC", "24h", "19:30"},
+		{"America/New_York", "12h", "3:30 PM"},
+		{"Europe/Berlin", "24h", "21:30"},
+		{"Mars/Olympus", "24h", "19:30"}, // Unknown zones fall back to UTC
+		{"UTC", "Jan 2 15:04", "May 1 19:30"},
+	}
+	for _, tt := range tests {
+		if got := formatTimestamp(at, tt.zone, tt.format); got != tt.want {
+			t.Errorf("formatTimestamp(%q, %q) = %q, want %q", tt.zone, tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestResolutionLabel(t *testing.T) {
+	tests := []struct {
+		name    string
+		session Session
+		want    string
+	}{
+		{"streamed resolution", Session{MediaType: "movie", VideoFullResolution: "4k", StreamVideoFullResolution: "1080p"}, "1080p"},
+		{"source resolution", Session{MediaType: "episode", VideoFullResolution: "720p"}, "720p"},
+		{"4K", Session{MediaType: "movie", VideoFullResolution: "4k"}, "4K"},
+		{"music", Session{MediaType: "track", VideoFullResolution: "1080p"}, ""},
+		{"unknown", Session{MediaType: "movie"}, ""},
+	}
+	for _, tt := range tests {
+		if got := resolutionLabel(&tt.session); got != tt.want {
+			t.Errorf("%s: resolutionLabel = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// readMarkup returns the contents of one of the Liquid templates.
+func readMarkup(t *testing.T, name string) string {
+	t.Helper()
+	markup, err := os.ReadFile(name + ".liquid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(markup)
+}
+
+func TestMusicSession(t *testing.T) {
+	upstream, _ := fakeTautulli(t, activityBody(`{"session_key":"3","user":"carol","grandparent_title":"Radiohead","parent_title":"OK Computer","title":"Airbag","media_type":"track","progress_percent":"30"}`))
+	page := decodePage(t, get(configuredServer(upstream), "/"))
+	if len(page.Sessions) != 1 {
+		t.Fatalf("got %d sessions, want 1", len(page.Sessions))
+	}
+	session := page.Sessions[0]
+	if session.GrandparentTitle != "Radiohead" || session.ParentTitle != "OK Computer" || session.Title != "Airbag" {
+		t.Errorf("got artist %q, album %q, track %q", session.GrandparentTitle, session.ParentTitle, session.Title)
+	}
+
+	// The default view's music branch names the artist, album and track.
+	_, markup, _ := strings.Cut(readMarkup(t, "full"), "content--large")
+	_, track, ok := strings.Cut(markup, "{% elsif session.media_type == 'track' %}")
+	if !ok {
+		t.Fatal("full.liquid has no branch for music")
+	}
+	track, _, _ = strings.Cut(track, "{% else %}")
+	for _, field := range []string{"session.grandparent_title", "session.parent_title", "session.title"} {
+		if !strings.Contains(track, field) {
+			t.Errorf("the music branch doesn't show %s", field)
+		}
+	}
+}
+
+func TestStateLabel(t *testing.T) {
+	labels := builtinCatalogs[defaultLang]
+	tests := []struct {
+		state, want string
+	}{
+		{"playing", "▶ Playing"},
+		{"paused", "❚❚ Paused"},
+		{"buffering", "… Buffering"},
+		{"Paused", "❚❚ Paused"},
+		{"stopped", "stopped"}, // Unknown states are shown as they are
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := stateLabel(tt.state, labels); got != tt.want {
+			t.Errorf("stateLabel(%q) = %q, want %q", tt.state, got, tt.want)
+		}
+	}
+
+	// The markup dims paused sessions through their state class.
+	for _, layout := range []string{"full", "half_horizontal", "half_vertical", "quadrant"} {
+		markup := readMarkup(t, layout)
+		if !strings.Contains(markup, "session--{{ session.state }}") || !strings.Contains(markup, ".session--paused {") {
+			t.Errorf("%s.liquid doesn't style sessions by their state", layout)
+		}
+	}
+}
+
+const trackJSON = `{"session_key":"3","user":"Alice","player":"Kitchen","grandparent_title":"Radiohead","parent_title":"OK Computer","title":"Airbag","media_type":"track","progress_percent":"30","state":"playing"}`
+
+func TestUserFilter(t *testing.T) {
+	upstream, _ := fakeTautulli(t, activityBody(episodeJSON, movieJSON, trackJSON))
+	s := configuredServer(upstream)
+
+	tests := []struct {
+		users string
+		want  []string
+	}{
+		{"alice", []string{"The Dundies", "Airbag"}}, // Case-insensitive
+		{"bob,ALICE", []string{"The Dundies", "Airbag", "Heat"}},
+		{"dave", []string{}},
+	}
+	for _, tt := range tests {
+		page := decodePage(t, get(s, "/?users="+tt.users))
+		if got := titles(page.Sessions); !slices.Equal(got, tt.want) {
+			t.Errorf("users=%s: sessions = %q, want %q", tt.users, got, tt.want)
+		}
+		if page.StreamCount != len(tt.want) {
+			t.Errorf("users=%s: stream_count = %d, want only the matching %d", tt.users, page.StreamCount, len(tt.want))
+		}
+	}
+}
+
+func TestMediaTypeFilter(t *testing.T) {
+	upstream, _ := fakeTautulli(t, activityBody(append(numberedSessions(5), episodeJSON, movieJSON, trackJSON)...))
+	s := configuredServer(upstream)
+
+	tests := []struct {
+		name, mediaTypes string
+		want             []string
+	}{
+		{"video only", "movie,episode", []string{"The Dundies", "Heat"}},
+		{"music only", "TRACK", []string{"Airbag"}},
+		{"unknown type ignored", "track,podcast", []string{"Airbag"}},
+		{"only unknown types", "podcast", []string{"Session 1", "Session 2", "Session 3", "Session 4"}},
+	}
+	for _, tt := range tests {
+		page := decodePage(t, get(s, "/?media_types="+tt.mediaTypes))
+		if got := titles(page.Sessions); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: sessions = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFormatTimecode(t *testing.T) {
+	tests := []struct {
+		name                 string
+		duration, viewOffset int
+		want                 string
+	}{
+		{"under a minute", 45000, 9000, "0:09 / 0:45"},
+		{"minutes", 2710000, 754000, "12:34 / 45:10"},
+		{"over an hour", 5025000, 3600000, "1:00:00 / 1:23:45"},
+		{"offset past the end", 60000, 90000, "1:00 / 1:00"},
+		{"unknown duration", 0, 1000, ""},
+	}
+	for _, tt := range tests {
+		if got := formatTimecode(tt.duration, tt.viewOffset); got != tt.want {
+			t.Errorf("%s: formatTimecode(%d, %d) = %q, want %q", tt.name, tt.duration, tt.viewOffset, got, tt.want)
+		}
+	}
+}
+
+func TestPlayerLabel(t *testing.T) {
+	upstream, _ := fakeTautulli(t, activityBody(episodeJSON,
+		`{"session_key":"2","title":"Heat","player":"Chromecast with Google TV (Bedroom)","progress_percent":"10"}`))
+	page := decodePage(t, get(configuredServer(upstream), "/"))
+
+	want := []string{"Living Room TV", "Chromecast with Goo…"}
+	if len(page.Sessions) != len(want) {
+		t.Fatalf("got %d sessions, want %d", len(page.Sessions), len(want))
+	}
+	for i, session := range page.Sessions {
+		if session.PlayerLabel != want[i] {
+			t.Errorf("%s: player_label = %q, want %q", session.Title, session.PlayerLabel, want[i])
+		}
+	}
+	if !strings.Contains(readMarkup(t, "full"), "{{ session.player_label }}") {
+		t.Error("full.liquid doesn't show the player")
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"Heat", 10, "Heat"},
+		{"The Lord of the Rings", 10, "The Lord…"},
+		{"Exactly Ten", 11, "Exactly Ten"},
+		{"千と千尋の神隠し", 5, "千と千尋…"},
+		{"Amélie Poulain", 7, "Amélie…"},
+		{"  padded  ", 6, "padded"},
+	}
+	for _, tt := range tests {
+		got := truncate(tt.s, tt.n)
+		if got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("truncate(%q, %d) cut a character in half", tt.s, tt.n)
+		}
+	}
+}
+
+func TestMaxTitleLength(t *testing.T) {
+	upstream, _ := fakeTautulli(t, activityBody(`{"session_key":"1","grandparent_title":"Star Trek: The Next Generation","title":"The Measure of a Man","media_type":"episode","progress_percent":"5"}`))
+	cfg := testConfig()
+	cfg.TautulliURL = upstream.URL
+	cfg.APIKey = "key"
+	cfg.MaxTitleLength = 12
+
+	page := decodePage(t, get(newServer(cfg), "/"))
+	if len(page.Sessions) != 1 {
+		t.Fatalf("got %d sessions, want 1", len(page.Sessions))
+	}
+	if got := page.Sessions[0]; got.GrandparentTitle != "Star Trek:…" || got.Title != "The Measure…" {
+		t.Errorf("got %q and %q", got.GrandparentTitle, got.Title)
+	}
+}
+
+func TestBandwidthLimit(t *testing.T) {
+	// 12 Mbps over the WAN
+	body := strings.Replace(activityBody(movieJSON), `"wan_bandwidth":0`, `"wan_bandwidth":12000`, 1)
+	upstream, _ := fakeTautulli(t, body)
+	s := configuredServer(upstream)
+
+	tests := []struct {
+		limit string
+		want  bool
+	}{
+		{"", false}, // No limit by default
+		{"20", false},
+		{"12", false},
+		{"10", true},
+		{"0", false}, // 0 turns the warning off
+	}
+	for _, tt := range tests {
+		page := decodePage(t, get(s, "/?bandwidth_limit="+tt.limit))
+		if page.OverLimit != tt.want {
+			t.Errorf("bandwidth_limit=%s: over_limit = %t, want %t", tt.limit, page.OverLimit, tt.want)
+		}
+	}
+	// 0 also turns off a BANDWIDTH_LIMIT set for all requests.
+	cfg := testConfig()
+	cfg.TautulliURL = upstream.URL
+	cfg.APIKey = "key"
+	cfg.BandwidthLimit = 10
+	if page := decodePage(t, get(newServer(cfg), "/?bandwidth_limit=0")); page.OverLimit {
+		t.Error("bandwidth_limit=0 didn't turn off BANDWIDTH_LIMIT")
+	}
+	if rec := get(s, "/?bandwidth_limit=-1"); rec.Code != http.StatusBadRequest {
+		t.Errorf("negative bandwidth_limit: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestStartedLabel(t *testing.T) {
+	started := time.Date(2024, 5, 1, 0, 15, 0, 0, time.UTC).Unix()
+	upstream, _ := fakeTautulli(t, activityBody(
+		`{"session_key":"1","title":"Heat","progress_percent":"50","started":"`+strconv.FormatInt(started, 10)+`"}`,
+		`{"session_key":"2","title":"Ronin","progress_percent":"40","started":"0"}`,
+		`{"session_key":"3","title":"Collateral","progress_percent":"30"}`,
+	))
+	page := decodePage(t, get(configuredServer(upstream), "/?timezone=America/Los_Angeles&time_format=12h"))
+
+	want := map[string]string{"Heat": "Started 5:15 PM", "Ronin": "", "Collateral": ""}
+	for _, session := range page.Sessions {
+		if session.StartedLabel != want[session.Title] {
+			t.Errorf("%s: started_label = %q, want %q", session.Title, session.StartedLabel, want[session.Title])
+		}
+	}
+}
+
+func TestEpisodeLabel(t *testing.T) {
+	tests := []struct {
+		name    string
+		session Session
+		want    string
+	}{
+		{"sample episode", Session{MediaType: "episode", ParentMediaIndex: "2", MediaIndex: "5"}, "S02E05"},
+		{"long-running show", Session{MediaType: "episode", ParentMediaIndex: "31", MediaIndex: "112"}, "S31E112"},
+		{"missing numbers", Session{MediaType: "episode", ParentMediaIndex: "1"}, ""},
+		{"movie", Session{MediaType: "movie", ParentMediaIndex: "1", MediaIndex: "1"}, ""},
+	}
+	for _, tt := range tests {
+		if got := episodeLabel(&tt.session); got != tt.want {
+			t.Errorf("%s: episodeLabel = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHideUsers(t *testing.T) {
+	avatar := strings.Replace(episodeJSON, `"user":"alice"`, `"user":"alice","user_thumb":"https://plex.tv/users/1/avatar"`, 1)
+	upstream, _ := fakeTautulli(t, activityBody(avatar, movieJSON))
+	s := configuredServer(upstream)
+
+	rec := get(s, "/?hide_users=true")
+	page := decodePage(t, rec)
+	for _, name := range []string{"alice", "bob", "plex.tv"} {
+		if strings.Contains(rec.Body.String(), name) {
+			t.Errorf("the response mentions %q", name)
+		}
+	}
+	for _, session := range page.Sessions {
+		if session.User != "Someone" {
+			t.Errorf("%s: user = %q, want Someone", session.Title, session.User)
+		}
+	}
+	if !page.HideUsers || len(page.Sessions) != 2 {
+		t.Errorf("hide_users = %t with %d sessions, want true with the media still shown", page.HideUsers, len(page.Sessions))
+	}
+
+	// The users filter still matches the real names.
+	page = decodePage(t, get(s, "/?hide_users=true&users=bob"))
+	if got := titles(page.Sessions); !slices.Equal(got, []string{"Heat"}) {
+		t.Errorf("users=bob: sessions = %q, want [Heat]", got)
+	}
+}
+
+func TestLiveWidgetClass(t *testing.T) {
+	const class = "{% if session.state == 'playing' %} widget--live{% endif %}"
+	for _, layout := range []string{"full", "half_horizontal", "half_vertical", "quadrant"} {
+		if !strings.Contains(readMarkup(t, layout), class) {
+			t.Errorf("%s.liquid doesn't mark playing sessions with widget--live", layout)
+		}
+	}
+
+	// The class keys off the raw state, which must reach the markup unchanged.
+	upstream, _ := fakeTautulli(t, activityBody(episodeJSON, movieJSON))
+	page := decodePage(t, get(configuredServer(upstream), "/"))
+	want := map[string]string{"The Dundies": "playing", "Heat": "paused"}
+	for _, session := range page.Sessions {
+		if session.State != want[session.Title] {
+			t.Errorf("%s: state = %q, want %q", session.Title, session.State, want[session.Title])
+		}
+	}
+}
+
+func TestColumns(t *testing.T) {
+	upstream, _ := fakeTautulli(t, activityBody(episodeJSON))
+	s := configuredServer(upstream)
+
+	tests := []struct {
+		value      string
+		wantStatus int
+		want       int
+	}{
+		{"", http.StatusOK, 1}, // The layouts have always been a single column
+		{"1", http.StatusOK, 1},
+		{"2", http.StatusOK, 2},
+		{"3", http.StatusOK, 3},
+		{"0", http.StatusBadRequest, 0},
+		{"4", http.StatusBadRequest, 0},
+		{"two", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		rec := get(s, "/?columns="+tt.value)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("columns=%s: status = %d, want %d", tt.value, rec.Code, tt.wantStatus)
+			continue
+		}
+		if tt.wantStatus == http.StatusOK {
+			if page := decodePage(t, rec); page.Columns != tt.want {
+				t.Errorf("columns=%s: columns = %d, want %d", tt.value, page.Columns, tt.want)
+			}
+		}
+	}
+
+	const grid = `{% if columns > 1 %}<div class="grid grid--cols-{{ columns }}">{% endif %}`
+	for _, layout := range []string{"full", "half_horizontal", "half_vertical", "quadrant"} {
+		if !strings.Contains(readMarkup(t, layout), grid) {
+			t.Errorf("%s.liquid doesn't pick the grid class from columns", layout)
+		}
+	}
+}
+
+func TestLiveSession(t *testing.T) {
+	upstream, _ := fakeTautulli(t, activityBody(
+		`{"session_key":"1","title":"Evening News","media_type":"live","channel_title":"KQED","duration":"","view_offset":"600000","progress_percent":"0","state":"playing"}`,
+		`{"session_key":"2","title":"Game 7","media_type":"episode","live":"1","channel_title":"ESPN","duration":"3600000","view_offset":"600000","progress_percent":"17"}`,
+	))
+	page := decodePage(t, get(configuredServer(upstream), "/"))
+	if len(page.Sessions) != 2 {
+		t.Fatalf("got %d sessions, want 2", len(page.Sessions))
+	}
+	for _, session := range page.Sessions {
+		if session.LiveLabel != "LIVE" {
+			t.Errorf("%s: live_label = %q, want LIVE", session.Title, session.LiveLabel)
+		}
+		if !session.ProgressUnknown || session.Timecode != "" || session.TimeRemaining != "" {
+			t.Errorf("%s: got progress_unknown %t, timecode %q, time_remaining %q; want no progress shown",
+				session.Title, session.ProgressUnknown, session.Timecode, session.TimeRemaining)
+		}
+		if session.ChannelTitle == "" {
+			t.Errorf("%s: the channel wasn't passed through", session.Title)
+		}
+	}
+	if !strings.Contains(readMarkup(t, "full"), "{% if session.live_label and session.channel_title %}{{ session.channel_title }}") {
+		t.Error("full.liquid doesn't show the channel of live sessions")
+	}
+}
+
+func TestResponseKeys(t *testing.T) {
+	upstream, _ := fakeTautulli(t, activityBody(episodeJSON))
+	rec := get(configuredServer(upstream), "/")
+
+	var response map[string]json.RawMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"columns", "delta", "empty_message", "hide_users", "labels", "lan_bandwidth", "over_limit", "page", "pages", "sessions",
+		"show_percent", "stale", "stream_count", "theme", "timestamp", "total_bandwidth", "updated_at", "view", "wan_bandwidth",
+	}
+	if got := slices.Sorted(maps.Keys(response)); !slices.Equal(got, want) {
+		t.Errorf("keys = %q, want %q", got, want)
+	}
+
+	var updatedAt string
+	if err := json.Unmarshal(response["updated_at"], &updatedAt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := time.Parse(time.RFC3339, updatedAt); err != nil {
+		t.Errorf("updated_at %q isn't RFC 3339: %v", updatedAt, err)
+	}
+
+	var sessions []map[string]json.RawMessage
+	if err := json.Unmarshal(response["sessions"], &sessions); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"id", "user", "title", "grandparent_title", "media_type", "poster_url", "progress", "progress_unknown", "player_label", "state"} {
+		if _, ok := sessions[0][key]; !ok {
+			t.Errorf("sessions are missing %q", key)
+		}
+	}
+}
+
+func TestAvatarURL(t *testing.T) {
+	server := tautulliServer{URL: "http://tautulli.lan:8181", APIKey: "key"}
+	size := strconv.Itoa(avatarSize)
+	tests := []struct {
+		thumb, want string
+	}{
+		{"https://plex.tv/users/abc/avatar?c=1", "https://plex.tv/users/abc/avatar?c=1"},
+		{"/library/metadata/1/thumb", server.URL + "/api/v2?apikey=key&cmd=pms_image_proxy&img=%2Flibrary%2Fmetadata%2F1%2Fthumb&width=" + size + "&height=" + size},
+		{"", ""}, // No avatar is shown
+	}
+	for _, tt := range tests {
+		if got := avatarURL(tt.thumb, server); got != tt.want {
+			t.Errorf("avatarURL(%q) = %q, want %q", tt.thumb, got, tt.want)
+		}
+	}
+}
+
+func TestSortDecidesWhichSessionsFit(t *testing.T) {
+	upstream, _ := fakeTautulli(t, activityBody(
+		`{"session_key":"1","title":"Heat","progress_percent":"10","bandwidth":"20000"}`,
+		`{"session_key":"2","title":"Ronin","progress_percent":"90","bandwidth":"1500"}`,
+		`{"session_key":"3","title":"Collateral","progress_percent":"50","bandwidth":"8000"}`,
+	))
+	s := configuredServer(upstream)
+
+	tests := []struct {
+		sort string
+		want []string
+	}{
+		{"", []string{"Ronin", "Collateral"}}, // -progress
+		{"-progress", []string{"Ronin", "Collateral"}},
+		{"-bandwidth", []string{"Heat", "Collateral"}},
+		{"first", []string{"Heat", "Ronin"}},
+	}
+	for _, tt := range tests {
+		page := decodePage(t, get(s, "/?layout=half_horizontal&sort="+tt.sort))
+		if got := titles(page.Sessions); !slices.Equal(got, tt.want) {
+			t.Errorf("sort=%s: sessions = %q, want %q", tt.sort, got, tt.want)
+		}
+	}
+	if rec := get(s, "/?sort=rating"); rec.Code != http.StatusBadRequest {
+		t.Errorf("sort=rating: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestCompactView(t *testing.T) {
+	avatar := strings.Replace(episodeJSON, `"user":"alice"`, `"user":"alice","user_thumb":"https://plex.tv/users/1/avatar"`, 1)
+	upstream, _ := fakeTautulli(t, activityBody(avatar, movieJSON))
+	rec := get(configuredServer(upstream), "/?view=compact")
+
+	page := decodePage(t, rec)
+	if page.View != "compact" || len(page.Sessions) != 2 {
+		t.Fatalf("got view %q with %d sessions, want compact with 2", page.View, len(page.Sessions))
+	}
+	for _, session := range page.Sessions {
+		if session.PosterURL != "" || session.AvatarURL != "" {
+			t.Errorf("%s: got poster %q and avatar %q in the compact view", session.Title, session.PosterURL, session.AvatarURL)
+		}
+	}
+	for _, image := range []string{"pms_image_proxy", "placehold.co"} {
+		if strings.Contains(rec.Body.String(), image) {
+			t.Errorf("the compact view links to %s", image)
+		}
+	}
+}
+
+func TestHidePaused(t *testing.T) {
+	pausedTrack := strings.Replace(trackJSON, `"state":"playing"`, `"state":"paused"`, 1)
+	upstream, _ := fakeTautulli(t, activityBody(episodeJSON, movieJSON, pausedTrack))
+	s := configuredServer(upstream)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"hide_paused=true", []string{"The Dundies"}},
+		{"hide_paused=false", []string{"The Dundies", "Airbag", "Heat"}},
+		{"hide_paused=true&users=bob", []string{}},
+		{"hide_paused=true&media_types=episode,track", []string{"The Dundies"}},
+	}
+	for _, tt := range tests {
+		page := decodePage(t, get(s, "/?"+tt.query))
+		if got := titles(page.Sessions); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: sessions = %q, want %q", tt.query, got, tt.want)
+		}
+		if page.StreamCount != len(tt.want) {
+			t.Errorf("%s: stream_count = %d, want %d", tt.query, page.StreamCount, len(tt.want))
+		}
+	}
+}
+
+func TestPosterThumb(t *testing.T) {
+	tests := []struct {
+		name    string
+		session Session
+		want    string
+	}{
+		{"own thumbnail", Session{Thumb: "/thumb", ParentThumb: "/parent", GrandparentThumb: "/grandparent", Art: "/art"}, "/thumb"},
+		{"album art", Session{ParentThumb: "/parent", GrandparentThumb: "/grandparent", Art: "/art"}, "/parent"},
+		{"show art", Session{GrandparentThumb: "/grandparent", Art: "/art"}, "/grandparent"},
+		{"background art", Session{Art: "/art"}, "/art"},
+		{"nothing", Session{}, ""},
+	}
+	for _, tt := range tests {
+		if got := posterThumb(&tt.session); got != tt.want {
+			t.Errorf("%s: posterThumb = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestShowPercent(t *testing.T) {
+	upstream, _ := fakeTautulli(t, activityBody(episodeJSON))
+	s := configuredServer(upstream)
+
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"layout=full", true}, // The full layout has always shown it
+		{"layout=half_horizontal", false},
+		{"layout=quadrant&show_percent=true", true},
+		{"layout=full&show_percent=false", false},
+	}
+	for _, tt := range tests {
+		if page := decodePage(t, get(s, "/?"+tt.query)); page.ShowPercent != tt.want {
+			t.Errorf("%s: show_percent = %t, want %t", tt.query, page.ShowPercent, tt.want)
+		}
+	}
+
+	for _, layout := range []string{"full", "half_horizontal", "half_vertical", "quadrant"} {
+		_, percent, ok := strings.Cut(readMarkup(t, layout), "{% if show_percent %}")
+		if percent, _, _ = strings.Cut(percent, "{% endif %}"); !ok || !strings.Contains(percent, "{{ session.progress }}%") {
+			t.Errorf("%s.liquid doesn't show the percentage when show_percent is set", layout)
+		}
+	}
+}
+
+func TestNullSessions(t *testing.T) {
+	body := `{"response":{"result":"success","message":null,"data":{"stream_count":"0","sessions":null}}}`
+	upstream, _ := fakeTautulli(t, body)
+	s := configuredServer(upstream)
+
+	for _, query := range []string{"", "?users=alice&hide_paused=true", "?view=compact&hide_users=true&page=2"} {
+		rec := get(s, "/"+query)
+		page := decodePage(t, rec)
+		if page.StreamCount != 0 || page.EmptyMessage != "Nothing is currently playing." {
+			t.Errorf("%q: got stream_count %d and empty_message %q", query, page.StreamCount, page.EmptyMessage)
+		}
+		if !strings.Contains(rec.Body.String(), `"sessions":[]`) {
+			t.Errorf("%q: sessions aren't an empty list, which the markup's empty state expects", query)
+		}
+	}
+	if rec := get(s, "/summary"); rec.Code != http.StatusOK {
+		t.Errorf("/summary: status = %d, want 200", rec.Code)
+	}
+}
+
+func TestSyncedSession(t *testing.T) {
+	synced := strings.Replace(movieJSON, `"state":"paused"`, `"state":"playing","synced_version":"1"`, 1)
+	upstream, _ := fakeTautulli(t, activityBody(synced, episodeJSON))
+	page := decodePage(t, get(configuredServer(upstream), "/"))
+
+	want := map[string]string{"Heat": "Synced", "The Dundies": ""}
+	for _, session := range page.Sessions {
+		if session.SyncedLabel != want[session.Title] {
+			t.Errorf("%s: synced_label = %q, want %q", session.Title, session.SyncedLabel, want[session.Title])
+		}
+		if session.LiveLabel != "" {
+			t.Errorf("%s: a synced session is labelled %q", session.Title, session.LiveLabel)
+		}
+	}
+	if !strings.Contains(readMarkup(t, "full"), "{% if session.synced_label %}") {
+		t.Error("full.liquid doesn't label synced sessions")
+	}
+}
+
+func TestConfiguredKeyNeverInResponse(t *testing.T) {
+	avatar := strings.Replace(episodeJSON, `"user":"alice"`, `"user":"alice","user_thumb":"/library/user/1/thumb"`, 1)
+	upstream, _ := fakeTautulli(t, activityBody(avatar, movieJSON, trackJSON))
+	cfg := testConfig()
+	cfg.TautulliURL = upstream.URL
+	cfg.APIKey = "supersecret"
+	s := newServer(cfg)
+
+	for _, path := range []string{"/", "/?layout=quadrant", "/?history=true", "/summary"} {
+		rec := get(s, path)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want 200", path, rec.Code)
+		}
+		if strings.Contains(rec.Body.String(), "supersecret") {
+			t.Errorf("%s: the response contains TAUTULLI_API_KEY: %s", path, rec.Body)
+		}
+	}
+
+	// Only the placeholder is shown in place of posters.
+	page := decodePage(t, get(s, "/"))
+	for _, session := range page.Sessions {
+		if !strings.HasPrefix(session.PosterURL, "https://placehold.co/") || session.AvatarURL != "" {
+			t.Errorf("%s: got poster %q and avatar %q", session.Title, session.PosterURL, session.AvatarURL)
+		}
+	}
+
+	// Recently watched items are shown without posters too.
+	history := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("cmd") == "get_history" {
+			io.WriteString(w, `{"response":{"result":"success","data":{"data":[{"user":"bob","title":"Heat","media_type":"movie","thumb":"/library/metadata/2/thumb/1","percent_complete":100,"stopped":1714600000}]}}}`)
+			return
+		}
+		io.WriteString(w, activityBody())
+	}))
+	defer history.Close()
+	cfg.TautulliURL = history.URL
+	rec := get(newServer(cfg), "/?history=true")
+	if page := decodePage(t, rec); len(page.Recent) != 1 || strings.Contains(rec.Body.String(), "supersecret") {
+		t.Errorf("got %d recently watched items, and the key leaked: %t", len(page.Recent), strings.Contains(rec.Body.String(), "supersecret"))
+	}
+
+	// A key from the request is the caller's own, so it may be used.
+	page = decodePage(t, get(s, "/?api_key=mine"))
+	if !strings.Contains(page.Sessions[0].PosterURL, "apikey=mine&cmd=pms_image_proxy") {
+		t.Errorf("poster_url = %q, want it loaded through Tautulli", page.Sessions[0].PosterURL)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tautulli_trmnl_requests_total",
+		Help: "Requests handled, by handler and status code.",
+	}, []string{"handler", "code"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tautulli_trmnl_request_duration_seconds",
+		Help:    "Time spent handling requests, by handler and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"handler", "code"})
+
+	upstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tautulli_trmnl_upstream_request_duration_seconds",
+		Help:    "Time spent on each request to Tautulli, by status code or \"error\".",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"code"})
+
+	upstreamErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "tautulli_trmnl_upstream_errors_total",
+		Help: "Requests to Tautulli that failed to connect or returned a 5xx status.",
+	})
+
+	lastFetchSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "tautulli_last_fetch_success_timestamp_seconds",
+		Help: "Unix time of the last successful activity fetch from Tautulli.",
+	})
+
+	cacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tautulli_trmnl_cache_lookups_total",
+		Help: "Activity cache lookups, by result (hit or miss).",
+	}, []string{"result"})
+)
+
+// instrument wraps a handler to record request counts and durations under the
+// given handler name.
+func instrument(name string, next http.HandlerFunc) http.HandlerFunc {
+	labels := prometheus.Labels{"handler": name}
+	return promhttp.InstrumentHandlerCounter(
+		requestsTotal.MustCurryWith(labels),
+		promhttp.InstrumentHandlerDuration(requestDuration.MustCurryWith(labels), next),
+	)
+}
+
+// metricsHandler serves the Prometheus metrics, along with the Go runtime and
+// process metrics (including process_start_time_seconds) that the default
+// registry collects. It doesn't need Tautulli credentials.
+func metricsHandler() http.Handler {
+	return promhttp.Handler()
+}
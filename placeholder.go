@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultPlaceholderURL is the poster shown for sessions without a thumbnail
+// unless PLACEHOLDER_URL or the placeholder_url parameter says otherwise.
+const defaultPlaceholderURL = "https://placehold.co/{width}x{height}/{background}/{foreground}?text=No+Art"
+
+// maxPlaceholderSize caps the dimensions handlePlaceholder will draw.
+const maxPlaceholderSize = 2000
+
+// placeholderURL fills in the {width}, {height}, {background} and
+// {foreground} tokens of a placeholder URL template.
+func placeholderURL(template string, layout Layout, colors Theme) string {
+	return strings.NewReplacer(
+		"{width}", strconv.Itoa(layout.PosterWidth),
+		"{height}", strconv.Itoa(layout.PosterHeight),
+		"{background}", colors.Background,
+		"{foreground}", colors.Foreground,
+	).Replace(template)
+}
+
+// baseURL returns the scheme and host that clients reach this server at, for
+// turning relative placeholder URLs into absolute ones. PUBLIC_BASE_URL takes
+// precedence; otherwise, when TRUST_FORWARDED_HEADERS is set, it is taken
+// from the X-Forwarded-Proto and X-Forwarded-Host headers set by a reverse
+// proxy. It returns an empty string when neither is configured, so relative
+// URLs are left as they are.
+func (s *Server) baseURL(r *http.Request) string {
+	if s.cfg.PublicBaseURL != "" {
+		return strings.TrimSuffix(s.cfg.PublicBaseURL, "/")
+	}
+	if !s.cfg.TrustForwardedHeaders {
+		return ""
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	host := r.Host
+	if forwarded := r.Header.Get("X-Forwarded-Host"); forwarded != "" {
+		host, _, _ = strings.Cut(forwarded, ",")
+		host = strings.TrimSpace(host)
+	}
+	return scheme + "://" + host
+}
+
+// requestPlaceholder returns the placeholder URL template for a request: the
+// placeholder_url parameter or PLACEHOLDER_URL, with a relative path made
+// absolute when a base URL is known.
+func (s *Server) requestPlaceholder(r *http.Request) string {
+	placeholder := r.URL.Query().Get("placeholder_url")
+	if placeholder == "" {
+		placeholder = s.cfg.PlaceholderURL
+	}
+	if strings.HasPrefix(placeholder, "/") && !strings.HasPrefix(placeholder, "//") {
+		placeholder = s.baseURL(r) + placeholder
+	}
+	return placeholder
+}
+
+// isHexColor reports whether s is a 3 or 6 digit hex color without the "#".
+func isHexColor(s string) bool {
+	if len(s) != 3 && len(s) != 6 {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// handlePlaceholder draws a "No Art" poster as SVG, so setups without
+// internet access can point PLACEHOLDER_URL at this server instead of
+// placehold.co. It takes the same width, height, background and foreground
+// values as the URL template.
+func (s *Server) handlePlaceholder(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	layout := getLayout("")
+	colors := themes["light"]
+
+	for name, dst := range map[string]*int{"width": &layout.PosterWidth, "height": &layout.PosterHeight} {
+		if value := query.Get(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > maxPlaceholderSize {
+				http.Error(w, fmt.Sprintf("%s must be a number between 1 and %d", name, maxPlaceholderSize), http.StatusBadRequest)
+				return
+			}
+			*dst = n
+		}
+	}
+	for name, dst := range map[string]*string{"background": &colors.Background, "foreground": &colors.Foreground} {
+		if value := query.Get(name); value != "" {
+			if !isHexColor(value) {
+				http.Error(w, fmt.Sprintf("%s must be a hex color such as eee", name), http.StatusBadRequest)
+				return
+			}
+			*dst = value
+		}
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+
+		`<rect width="100%%" height="100%%" fill="#%s"/>`+
+		`<text x="50%%" y="50%%" fill="#%s" font-family="sans-serif" font-size="%d" text-anchor="middle" dominant-baseline="middle">No Art</text>`+
+		`</svg>`,
+		layout.PosterWidth, layout.PosterHeight, layout.PosterWidth, layout.PosterHeight,
+		colors.Background, colors.Foreground, layout.PosterWidth/6)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAbsolutePosterURLs(t *testing.T) {
+	upstream, _ := fakeTautulli(t, activityBody(`{"session_key":"1","title":"No Art","progress_percent":"50"}`))
+	const placeholder = "/placeholder?width={width}&height={height}"
+	const path = "/placeholder?width=120&height=180"
+
+	tests := []struct {
+		name          string
+		publicBaseURL string
+		trustHeaders  bool
+		header        http.Header
+		want          string
+	}{
+		{"relative by default", "", false, http.Header{"X-Forwarded-Host": {"trmnl.example"}}, path},
+		{"PUBLIC_BASE_URL", "https://trmnl.example/", false, nil, "https://trmnl.example" + path},
+		{"forwarded headers", "", true, http.Header{"X-Forwarded-Proto": {"https"}, "X-Forwarded-Host": {"trmnl.example, proxy.lan"}}, "https://trmnl.example" + path},
+		{"request host", "", true, nil, "http://example.com" + path},
+		{"PUBLIC_BASE_URL over headers", "https://public.example", true, http.Header{"X-Forwarded-Host": {"trmnl.example"}}, "https://public.example" + path},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.TautulliURL = upstream.URL
+			cfg.APIKey = "key"
+			cfg.PlaceholderURL = placeholder
+			cfg.PublicBaseURL = tt.publicBaseURL
+			cfg.TrustForwardedHeaders = tt.trustHeaders
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for name, values := range tt.header {
+				req.Header[name] = values
+			}
+			rec := httptest.NewRecorder()
+			newServer(cfg).routes().ServeHTTP(rec, req)
+
+			page := decodePage(t, rec)
+			if len(page.Sessions) != 1 {
+				t.Fatalf("got %d sessions, want 1", len(page.Sessions))
+			}
+			if got := page.Sessions[0].PosterURL; got != tt.want {
+				t.Errorf("poster_url = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlaceholderSizes(t *testing.T) {
+	upstream, _ := fakeTautulli(t, activityBody(`{"session_key":"1","title":"No Art","progress_percent":"50"}`))
+	s := configuredServer(upstream)
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"layout=full", "https://placehold.co/120x180/eee/ccc?text=No+Art"},
+		{"layout=half_vertical", "https://placehold.co/90x135/eee/ccc?text=No+Art"},
+		{"layout=quadrant&theme=dark", "https://placehold.co/60x90/333/666?text=No+Art"},
+		{"layout=quadrant&poster_width=80&poster_height=120", "https://placehold.co/80x120/eee/ccc?text=No+Art"},
+	}
+	for _, tt := range tests {
+		page := decodePage(t, get(s, "/?"+tt.query))
+		if got := page.Sessions[0].PosterURL; got != tt.want {
+			t.Errorf("%s: poster_url = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+	if rec := get(s, "/?poster_width=0"); rec.Code != http.StatusBadRequest {
+		t.Errorf("poster_width=0: status = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// pollActivity fetches the activity of the configured Tautulli servers every
+// interval until ctx is cancelled, keeping the cache warm so that devices
+// polling without their own tautulli_url are answered without waiting on
+// Tautulli. It does nothing when no server is configured.
+func (s *Server) pollActivity(ctx context.Context, interval time.Duration) {
+	servers, err := pairServers(splitList(s.cfg.TautulliURL), splitList(s.cfg.APIKey), true, s.cfg.DefaultScheme, s.cfg.BasePath)
+	if err != nil {
+		slog.Warn("background polling is disabled: TAUTULLI_URL and TAUTULLI_API_KEY must be set", "error", err)
+		return
+	}
+	slog.Info("polling Tautulli in the background", "interval", interval.String(), "servers", len(servers))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.pollOnce(ctx, servers)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pollOnce refreshes the cached activity of each server, bounded by
+// REQUEST_TIMEOUT like a device request. The responses are cached until the
+// next poll.
+func (s *Server) pollOnce(ctx context.Context, servers []tautulliServer) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.cfg.RequestTimeout))
+	defer cancel()
+	for _, server := range servers {
+		s.refreshActivity(ctx, s.resolveScheme(server), pollTTL(s.cfg))
+	}
+}
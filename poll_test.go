@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPollOnceFillsCache(t *testing.T) {
+	upstream, requests := fakeTautulli(t, activityBody(episodeJSON))
+	cfg := testConfig()
+	cfg.TautulliURL = upstream.URL
+	cfg.APIKey = "key"
+	cfg.CacheTTL = 0
+	cfg.PollInterval = Duration(time.Minute)
+	s := newServer(cfg)
+
+	servers, err := pairServers([]string{upstream.URL}, []string{"key"}, true, cfg.DefaultScheme, cfg.BasePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.pollOnce(context.Background(), servers)
+
+	page := decodePage(t, get(s, "/"))
+	if page.StreamCount != 1 {
+		t.Errorf("stream_count = %d, want 1", page.StreamCount)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("Tautulli got %d requests, want only the poll's", n)
+	}
+}
+
+func TestPollingKeepsCacheTTLForRequestServers(t *testing.T) {
+	upstream, requests := fakeTautulli(t, activityBody(episodeJSON))
+	cfg := testConfig()
+	cfg.TautulliURL = "http://tautulli.invalid"
+	cfg.APIKey = "secret"
+	cfg.AllowedHosts = []string{"127.0.0.1"}
+	cfg.CacheTTL = 0
+	cfg.PollInterval = Duration(5 * time.Minute)
+	s := newServer(cfg)
+
+	for range 2 {
+		decodePage(t, get(s, "/?api_key=key&tautulli_url="+upstream.URL))
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("Tautulli got %d requests, want 2 with caching turned off", n)
+	}
+}
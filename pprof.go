@@ -0,0 +1,24 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+)
+
+// servePprof serves the runtime profiling endpoints under /debug/pprof/ at
+// addr. They get their own listener, separate from the plugin's endpoints, so
+// they are never reachable through the public port.
+func servePprof(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	slog.Warn("serving pprof profiling endpoints", "address", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		slog.Error("failed to serve pprof", "address", addr, "error", err)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// previewSessions is the sample activity served by handlePreview: a couple of
+// episodes, a movie, and a music track.
+var previewSessions = []Session{
+	{
+		User:                "alice",
+		State:               "playing",
+		Player:              "Living Room TV (Samsung QN90B)",
+		GrandparentTitle:    "The Office",
+		Title:               "The Dundies",
+		MediaType:           "episode",
+		ParentMediaIndex:    "2",
+		MediaIndex:          "1",
+		Summary:             "Michael hosts the annual office awards at a local restaurant.",
+		ProgressPercent:     "42",
+		Duration:            "1320000",
+		ViewOffset:          "554400",
+		TranscodeDecision:   "direct play",
+		VideoFullResolution: "1080p",
+		Bandwidth:           "8000",
+	},
+	{
+		User:                      "bob",
+		State:                     "paused",
+		Player:                    "iPhone",
+		Title:                     "Inception",
+		MediaType:                 "movie",
+		Summary:                   "A thief who steals corporate secrets through dreams is given one last job.",
+		ProgressPercent:           "93",
+		Duration:                  "8880000",
+		ViewOffset:                "8258400",
+		TranscodeDecision:         "transcode",
+		VideoFullResolution:       "4k",
+		StreamVideoFullResolution: "1080p",
+		Bandwidth:                 "12000",
+	},
+	{
+		User:              "carol",
+		State:             "playing",
+		Player:            "Plexamp",
+		GrandparentTitle:  "Radiohead",
+		ParentTitle:       "OK Computer",
+		Title:             "Airbag",
+		MediaType:         "track",
+		ProgressPercent:   "10",
+		Duration:          "284000",
+		ViewOffset:        "28400",
+		TranscodeDecision: "copy",
+		Bandwidth:         "320",
+	},
+	{
+		User:                "dave",
+		State:               "buffering",
+		Player:              "Chrome",
+		GrandparentTitle:    "Breaking Bad",
+		Title:               "Ozymandias",
+		MediaType:           "episode",
+		ParentMediaIndex:    "5",
+		MediaIndex:          "14",
+		Summary:             "Everyone copes with radically changed circumstances.",
+		ProgressPercent:     "67",
+		Duration:            "2820000",
+		ViewOffset:          "1889400",
+		TranscodeDecision:   "transcode",
+		VideoFullResolution: "720p",
+		Bandwidth:           "4000",
+	},
+}
+
+// handlePreview returns sample activity in the same JSON format as
+// handleActivity without contacting Tautulli, which is handy for iterating on the
+// Liquid markup. The count parameter selects how many sample sessions to
+// include, from 0 (the empty state) to 4.
+func (s *Server) handlePreview(w http.ResponseWriter, r *http.Request) {
+	count := len(previewSessions)
+	if value := r.URL.Query().Get("count"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || n > len(previewSessions) {
+			http.Error(w, "count must be a number between 0 and 4", http.StatusBadRequest)
+			return
+		}
+		count = n
+	}
+
+	layout := getLayout(r.URL.Query().Get("layout"))
+	theme := r.URL.Query().Get("theme")
+	if _, ok := themes[theme]; !ok {
+		theme = "light"
+	}
+	columns, err := parseColumns(r.URL.Query().Get("columns"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	view := parseView(r.URL.Query().Get("view"))
+	if err := parsePosterSize(r.URL.Query(), &layout); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	showPercent := layout.ShowPercent
+	if value := r.URL.Query().Get("show_percent"); value != "" {
+		showPercent, _ = strconv.ParseBool(value)
+	}
+
+	labels := s.labels(r.URL.Query().Get("lang"))
+	opts := renderOptions{
+		Layout:         layout,
+		Theme:          theme,
+		Placeholder:    s.requestPlaceholder(r),
+		MaxTitleLength: s.cfg.MaxTitleLength,
+		Timezone:       r.URL.Query().Get("timezone"),
+		TimeFormat:     s.requestTimeFormat(r),
+		Compact:        view == "compact",
+		Labels:         labels,
+	}
+
+	sessions := make([]Session, 0, count)
+	totalBandwidth := 0
+	for _, session := range previewSessions[:count] {
+		// Pretend each sample started playing from the beginning.
+		viewOffset, _ := strconv.Atoi(session.ViewOffset)
+		session.Started = strconv.FormatInt(time.Now().Add(-time.Duration(viewOffset)*time.Millisecond).Unix(), 10)
+		enrichSession(&session, tautulliServer{}, opts)
+		sessions = append(sessions, session)
+		bandwidth, _ := strconv.Atoi(session.Bandwidth)
+		totalBandwidth += bandwidth
+	}
+	if len(sessions) > layout.MaxSessions {
+		sessions = sessions[:layout.MaxSessions]
+	}
+
+	now := time.Now()
+	pageData := PageData{
+		StreamCount:      count,
+		Delta:            formatDelta(0, 0, false),
+		TotalBandwidth:   totalBandwidth,
+		WANBandwidth:     totalBandwidth,
+		BandwidthSummary: formatBandwidthSummary(totalBandwidth, totalBandwidth, 0, labels),
+		Sessions:         sessions,
+		Theme:            theme,
+		Columns:          columns,
+		View:             view,
+		ShowPercent:      showPercent,
+		EmptyMessage:     s.emptyMessage(labels),
+		Labels:           labels,
+		Timestamp:        formatTimestamp(now, opts.Timezone, opts.TimeFormat),
+		UpdatedAt:        now.UTC().Format(time.RFC3339),
+	}
+
+	writeJSON(w, r, "preview", pageData)
+}
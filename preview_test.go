@@ -0,0 +1,36 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestPreviewTimeFormat(t *testing.T) {
+	upstream, _ := fakeTautulli(t, activityBody(episodeJSON))
+	cfg := testConfig()
+	cfg.TautulliURL = upstream.URL
+	cfg.APIKey = "key"
+	cfg.TimeFormat = "12h"
+	s := newServer(cfg)
+
+	tests := []struct {
+		query string
+		want  *regexp.Regexp
+	}{
+		{"?timezone=UTC", regexp.MustCompile(`^\d{1,2}:\d{2} [AP]M$`)},
+		{"?timezone=UTC&time_format=24h", regexp.MustCompile(`^\d{2}:\d{2}$`)},
+	}
+	for _, tt := range tests {
+		for _, path := range []string{"/", "/preview"} {
+			page := decodePage(t, get(s, path+tt.query))
+			if !tt.want.MatchString(page.Timestamp) {
+				t.Errorf("%s%s: timestamp = %q, want it to match %s", path, tt.query, page.Timestamp, tt.want)
+			}
+			for _, session := range page.Sessions {
+				if session.StartedLabel != "" && !tt.want.MatchString(session.StartedLabel[len("Started "):]) {
+					t.Errorf("%s%s: started_label = %q", path, tt.query, session.StartedLabel)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestRateLimit(t *testing.T) {
+	upstream, _ := fakeTautulli(t, activityBody(episodeJSON))
+	cfg := testConfig()
+	cfg.TautulliURL = upstream.URL
+	cfg.APIKey = "key"
+	cfg.RateLimit = 1
+	cfg.RateBurst = 3
+	s := newServer(cfg)
+
+	// A different api_key on every request must not get a fresh bucket.
+	for i := 1; i <= 4; i++ {
+		rec := get(s, "/?api_key=key"+strconv.Itoa(i))
+		want := http.StatusOK
+		if i == 4 {
+			want = http.StatusTooManyRequests
+		}
+		if rec.Code != want {
+			t.Fatalf("request %d: status = %d, want %d", i, rec.Code, want)
+		}
+		if i == 4 && rec.Header().Get("Retry-After") == "" {
+			t.Error("429 response has no Retry-After header")
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Server serves the plugin's HTTP endpoints. It holds the configuration and
+// the shared state the handlers need, so that separate instances, such as
+// ones pointed at fake Tautulli servers, don't interfere with each other.
+type Server struct {
+	cfg            Config
+	client         *http.Client
+	cache          *activityCache
+	allowedHosts   map[string]bool
+	allowedOrigins map[string]bool
+	limiter        *rateLimiter
+	counts         *streamCounts
+	// schemeFallbacks maps https:// URLs that were guessed for local servers
+	// to the http:// URLs that worked instead.
+	schemeFallbacks sync.Map
+}
+
+// newServer returns a Server for the given configuration.
+func newServer(cfg Config) *Server {
+	if cfg.MaxSessions > maxSessionsLimit {
+		cfg.MaxSessions = maxSessionsLimit
+	}
+
+	s := &Server{
+		cfg:    cfg,
+		client: newHTTPClient(time.Duration(cfg.HTTPTimeout), cfg.InsecureSkipVerify, cfg.UserAgent),
+		cache:  newActivityCache(time.Duration(cfg.StaleTTL)),
+		counts: newStreamCounts(),
+	}
+	if len(cfg.AllowedHosts) > 0 {
+		s.allowedHosts = parseAllowedHosts(cfg.AllowedHosts)
+	}
+	s.allowedOrigins = parseAllowedOrigins(cfg.AllowedOrigins)
+	if cfg.RateLimit > 0 {
+		s.limiter = newRateLimiter(cfg.RateLimit, cfg.RateBurst)
+	}
+	return s
+}
+
+// pollTTL returns how long activity fetched by the background poller is
+// served from the cache: at least until the next poll has had time to finish,
+// so devices are always answered from the cache. Activity fetched for a
+// request uses CACHE_TTL, since no poll will refresh it.
+func pollTTL(cfg Config) time.Duration {
+	ttl := time.Duration(cfg.CacheTTL)
+	if poll := time.Duration(cfg.PollInterval); poll > 0 {
+		ttl = max(ttl, poll+time.Duration(cfg.RequestTimeout))
+	}
+	return ttl
+}
+
+// routes returns a handler serving all of the plugin's endpoints.
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/{$}", s.logRequests("activity", instrument("activity", s.cors(s.rateLimit(s.requireAuth(s.withDeadline(s.cacheControl(gzipResponses(s.handleActivity)))))))))
+	mux.HandleFunc("/preview", s.logRequests("preview", instrument("preview", s.cors(s.requireAuth(gzipResponses(s.handlePreview))))))
+	mux.HandleFunc("/summary", s.logRequests("summary", instrument("summary", s.cors(s.rateLimit(s.requireAuth(s.withDeadline(s.cacheControl(gzipResponses(s.handleSummary)))))))))
+	mux.HandleFunc("/placeholder.svg", s.handlePlaceholder)
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz)
+	mux.HandleFunc("/version", s.handleVersion)
+	mux.Handle("/metrics", metricsHandler())
+	mux.HandleFunc("/", handleNotFound)
+	return s.accessLog(withRequestIDs(mux))
+}
+
+// httpServer returns the HTTP server that serves s on addr. Its timeouts stop
+// slow or idle clients from holding connections open, both while it runs and
+// during a graceful shutdown. Responses may take up to REQUEST_TIMEOUT to
+// prepare, so the write timeout leaves room beyond it.
+func (s *Server) httpServer(addr string) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           s.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      time.Duration(s.cfg.RequestTimeout) + 10*time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+}
+
+// writeJSON encodes v as the JSON response. The body is encoded up front, so
+// it is sent with a Content-Length, and an encoding failure becomes a clean
+// 500 rather than a truncated response.
+func writeJSON(w http.ResponseWriter, r *http.Request, handler string, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "failed to encode JSON response", "handler", handler, "error", err)
+		return
+	}
+	body = append(body, '\n')
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Write(body)
+}
+
+// handleNotFound answers requests for unknown paths, such as /favicon.ico or
+// a mistyped polling URL, without contacting Tautulli.
+func handleNotFound(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "Not found. Point your TRMNL polling URL at / on this server, with your Tautulli URL and API key.", http.StatusNotFound)
+}
+
+// withDeadline bounds all the work a handler does for one request, including
+// retries and fetches from several Tautulli servers, to REQUEST_TIMEOUT.
+// Fetches still running when it passes fail with 504 Gateway Timeout.
+func (s *Server) withDeadline(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(s.cfg.RequestTimeout))
+		defer cancel()
+		next(w, r.WithContext(ctx))
+	}
+}
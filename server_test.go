@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnknownRoutes(t *testing.T) {
+	upstream, requests := fakeTautulli(t, activityBody(episodeJSON))
+	s := configuredServer(upstream)
+
+	for _, path := range []string{"/favicon.ico", "/index.html", "/summary/extra"} {
+		if rec := get(s, path); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status = %d, want 404", path, rec.Code)
+		}
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("unknown routes made %d requests to Tautulli", n)
+	}
+}
+
+func TestRequestDeadline(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer upstream.Close()
+	cfg := testConfig()
+	cfg.TautulliURL = upstream.URL
+	cfg.APIKey = "key"
+	cfg.RequestTimeout = Duration(100 * time.Millisecond)
+	cfg.RetryAttempts = 3
+
+	start := time.Now()
+	rec := get(newServer(cfg), "/")
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want 504; body: %s", rec.Code, rec.Body)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the request took %v despite a 100ms REQUEST_TIMEOUT", elapsed)
+	}
+}
+
+func TestContentLength(t *testing.T) {
+	upstream, _ := fakeTautulli(t, activityBody(episodeJSON, movieJSON))
+	s := configuredServer(upstream)
+
+	for _, path := range []string{"/", "/summary", "/preview"} {
+		rec := get(s, path)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want 200", path, rec.Code)
+		}
+		if got := rec.Header().Get("Content-Length"); got != strconv.Itoa(rec.Body.Len()) {
+			t.Errorf("%s: Content-Length = %q, want %d", path, got, rec.Body.Len())
+		}
+	}
+}
+
+func TestWriteJSONEncodingFailure(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeJSON(rec, httptest.NewRequest(http.MethodGet, "/", nil), "test", map[string]any{"bad": make(chan int)})
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "{") {
+		t.Errorf("a partial response was written: %q", rec.Body)
+	}
+}
+
+func TestHTTPServerTimeouts(t *testing.T) {
+	cfg := testConfig()
+	cfg.RequestTimeout = Duration(15 * time.Second)
+	server := newServer(cfg).httpServer(":8080")
+
+	if server.ReadHeaderTimeout <= 0 || server.ReadTimeout <= 0 || server.IdleTimeout <= 0 {
+		t.Errorf("got read header %v, read %v and idle %v timeouts, want all set", server.ReadHeaderTimeout, server.ReadTimeout, server.IdleTimeout)
+	}
+	if server.WriteTimeout <= 15*time.Second {
+		t.Errorf("WriteTimeout = %v, want more than REQUEST_TIMEOUT", server.WriteTimeout)
+	}
+}
+
+func TestSlowClientsAreDisconnected(t *testing.T) {
+	cfg := testConfig()
+	s := newServer(cfg)
+	server := s.httpServer("")
+	server.ReadHeaderTimeout = 100 * time.Millisecond
+	upstream := httptest.NewUnstartedServer(server.Handler)
+	upstream.Config = server
+	upstream.Start()
+	defer upstream.Close()
+
+	conn, err := net.Dial("tcp", upstream.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example\r\n") // Headers never finished
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Errorf("the connection wasn't closed after ReadHeaderTimeout: %v", err)
+	}
+}
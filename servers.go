@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tautulliServer is a Tautulli instance to fetch activity from.
+type tautulliServer struct {
+	URL    string
+	APIKey string
+	Label  string // Shown on each session when several servers are combined
+	// Trusted is set when the URL comes from the server's own configuration
+	// rather than the request, so it skips the host restrictions.
+	Trusted bool
+	// SchemeGuessed is set when the URL had no scheme and DEFAULT_SCHEME was
+	// added to it.
+	SchemeGuessed bool
+	// SecretKey is set when the API key is TAUTULLI_API_KEY rather than one
+	// from the request, so it must never appear in a response.
+	SecretKey bool
+}
+
+// splitList returns the comma-separated items in values, skipping blanks.
+func splitList(values ...string) []string {
+	var items []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// requestServers returns the Tautulli servers a request asks for. URLs and API
+// keys may be repeated or comma-separated and are paired up in order. Without
+// a tautulli_url, the configured servers are used, along with the configured
+// API keys unless the request supplies its own. The configured keys are never
+// paired with a URL from the request, so they can't be sent to another host.
+func (s *Server) requestServers(r *http.Request) ([]tautulliServer, error) {
+	query := r.URL.Query()
+
+	urls := splitList(query["tautulli_url"]...)
+	keys := splitList(query["api_key"]...)
+	trusted := len(urls) == 0
+	if !trusted && len(keys) == 0 {
+		return nil, errors.New("Missing required query parameter 'api_key': a 'tautulli_url' in the request needs its own API key")
+	}
+	secret := false
+	if trusted {
+		urls = splitList(s.cfg.TautulliURL)
+		if len(keys) == 0 {
+			keys, secret = splitList(s.cfg.APIKey), true
+		}
+	}
+
+	servers, err := pairServers(urls, keys, trusted, s.cfg.DefaultScheme, s.cfg.BasePath)
+	for i := range servers {
+		servers[i].SecretKey = secret
+	}
+	return servers, err
+}
+
+// pairServers pairs each Tautulli URL with the API key in the same position.
+func pairServers(urls, keys []string, trusted bool, scheme, basePath string) ([]tautulliServer, error) {
+	if len(urls) == 0 || len(keys) == 0 {
+		return nil, errors.New("Missing required query parameters: 'tautulli_url' and 'api_key'")
+	}
+	if len(urls) != len(keys) {
+		return nil, fmt.Errorf("Got %d Tautulli URLs but %d API keys; each 'tautulli_url' needs a matching 'api_key'", len(urls), len(keys))
+	}
+
+	servers := make([]tautulliServer, len(urls))
+	for i := range urls {
+		tautulliURL := withBasePath(normalizeURL(urls[i], scheme), basePath)
+		label := tautulliURL
+		if u, err := url.Parse(tautulliURL); err == nil && u.Hostname() != "" {
+			label = u.Hostname()
+		}
+		servers[i] = tautulliServer{URL: tautulliURL, APIKey: keys[i], Label: label, Trusted: trusted, SchemeGuessed: !hasScheme(urls[i])}
+	}
+	return servers, nil
+}
+
+// serverActivity is the result of fetching activity from one server.
+type serverActivity struct {
+	Server     tautulliServer
+	Data       TautulliResponse
+	StaleSince time.Time // Set when Data is a stale fallback
+	Err        *fetchError
+}
+
+// getActivity returns the activity for a server, reusing a recent response if
+// one is cached. If fetching fails, the last successful response is used as a
+// stale fallback when there is one.
+func (s *Server) getActivity(ctx context.Context, server tautulliServer) serverActivity {
+	server = s.resolveScheme(server)
+	if data, ok := s.cache.get(server.URL + "|" + server.APIKey); ok {
+		cacheLookups.WithLabelValues("hit").Inc()
+		return serverActivity{Server: server, Data: data}
+	}
+	cacheLookups.WithLabelValues("miss").Inc()
+	return s.refreshActivity(ctx, server, time.Duration(s.cfg.CacheTTL))
+}
+
+// resolveScheme switches a server whose https:// scheme was guessed to the
+// http:// URL that worked instead, if there is one.
+func (s *Server) resolveScheme(server tautulliServer) tautulliServer {
+	if fallback, ok := s.schemeFallbacks.Load(server.URL); ok && server.SchemeGuessed {
+		server.URL = fallback.(string)
+	}
+	return server
+}
+
+// refreshActivity fetches the activity for a server and caches it for ttl,
+// without looking in the cache first. If fetching fails, the last successful response
+// is used as a stale fallback when there is one.
+func (s *Server) refreshActivity(ctx context.Context, server tautulliServer, ttl time.Duration) serverActivity {
+	result := serverActivity{Server: server}
+	cacheKey := server.URL + "|" + server.APIKey
+	ctx = s.restrictHosts(ctx, server)
+
+	data, err := s.fetchActivity(ctx, server.URL, server.APIKey)
+	if err != nil && server.SchemeGuessed && strings.HasPrefix(server.URL, "https://") && isTLSFailure(err) && isLocalHost(server.URL) {
+		// Local Tautulli servers usually only speak plain HTTP, so try that
+		// and remember it if it works.
+		httpURL := "http://" + strings.TrimPrefix(server.URL, "https://")
+		slog.InfoContext(ctx, "Tautulli doesn't speak HTTPS, retrying over HTTP", "tautulli_url", redactURL(httpURL))
+		if data, err = s.fetchActivity(ctx, httpURL, server.APIKey); err == nil {
+			s.schemeFallbacks.Store(server.URL, httpURL)
+			server.URL = httpURL
+			result.Server = server
+			cacheKey = server.URL + "|" + server.APIKey
+		}
+	}
+	if err == nil {
+		lastFetchSuccess.SetToCurrentTime()
+		s.cache.set(cacheKey, data, ttl)
+		result.Data = data
+		return result
+	}
+
+	stale, fetched, ok := s.cache.getStale(cacheKey)
+	if !ok {
+		slog.ErrorContext(ctx, "failed to fetch Tautulli activity", "handler", "activity", "tautulli_url", redactURL(server.URL), "error", err)
+		if !errors.As(err, &result.Err) {
+			result.Err = &fetchError{http.StatusInternalServerError, "Failed to fetch Tautulli activity", err}
+		}
+		return result
+	}
+	slog.WarnContext(ctx, "failed to fetch Tautulli activity, serving stale data", "handler", "activity", "tautulli_url", redactURL(server.URL), "stale_since", fetched, "error", err)
+	result.Data, result.StaleSince = stale, fetched
+	return result
+}
+
+// getActivities fetches the activity for each server concurrently. The results
+// are in the same order as servers.
+func (s *Server) getActivities(ctx context.Context, servers []tautulliServer) []serverActivity {
+	results := make([]serverActivity, len(servers))
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = s.getActivity(ctx, server)
+		}()
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSchemeFallback(t *testing.T) {
+	upstream, requests := fakeTautulli(t, activityBody(episodeJSON))
+	cfg := testConfig()
+	cfg.BlockPrivateHosts = false
+	s := newServer(cfg)
+	hostPort := strings.TrimPrefix(upstream.URL, "http://")
+
+	// A local server without a scheme is tried over HTTPS, then over HTTP.
+	page := decodePage(t, get(s, "/?api_key=key&tautulli_url="+hostPort))
+	if page.StreamCount != 1 {
+		t.Errorf("stream_count = %d, want 1", page.StreamCount)
+	}
+	if fallback, ok := s.schemeFallbacks.Load("https://" + hostPort); !ok || fallback != upstream.URL {
+		t.Errorf("remembered fallback = %v, want %s", fallback, upstream.URL)
+	}
+
+	// The fallback is remembered, so the next request goes straight to HTTP.
+	s.cache = newActivityCache(0)
+	decodePage(t, get(s, "/?api_key=key&tautulli_url="+hostPort))
+	if n := requests.Load(); n != 2 {
+		t.Errorf("Tautulli got %d requests, want 2", n)
+	}
+
+	// An explicit https:// is never downgraded.
+	if rec := get(s, "/?api_key=key&tautulli_url=https://"+hostPort); rec.Code == http.StatusOK {
+		t.Error("an https:// URL fell back to HTTP")
+	}
+}
+
+func TestDefaultScheme(t *testing.T) {
+	servers, err := pairServers([]string{"tautulli.lan:8181", "https://tautulli.example"}, []string{"a", "b"}, true, "http", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if servers[0].URL != "http://tautulli.lan:8181" || !servers[0].SchemeGuessed {
+		t.Errorf("got %q (guessed %t), want DEFAULT_SCHEME added", servers[0].URL, servers[0].SchemeGuessed)
+	}
+	if servers[1].URL != "https://tautulli.example" || servers[1].SchemeGuessed {
+		t.Errorf("got %q (guessed %t), want the URL unchanged", servers[1].URL, servers[1].SchemeGuessed)
+	}
+}
+
+func TestIsLocalHost(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://192.168.1.10:8181", true},
+		{"https://10.0.0.5", true},
+		{"https://tautulli:8181", true},
+		{"https://nas.local", true},
+		{"https://tautulli.home.arpa", true},
+		{"https://8.8.8.8", false},
+		{"https://tautulli.example.com", false},
+	}
+	for _, tt := range tests {
+		if got := isLocalHost(tt.url); got != tt.want {
+			t.Errorf("isLocalHost(%q) = %t, want %t", tt.url, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultSort is the session order used when no sort parameter is given.
+const defaultSort = "-progress"
+
+// sessionLess reports whether session a should be ordered before session b.
+type sessionLess func(a, b *Session) bool
+
+// sessionSorts maps the supported sort keys to their ascending comparisons.
+var sessionSorts = map[string]sessionLess{
+	"progress": func(a, b *Session) bool {
+		return a.Progress < b.Progress
+	},
+	"started": func(a, b *Session) bool {
+		aStarted, _ := strconv.ParseInt(a.Started, 10, 64)
+		bStarted, _ := strconv.ParseInt(b.Started, 10, 64)
+		return aStarted < bStarted
+	},
+	"user": func(a, b *Session) bool {
+		return strings.ToLower(a.User) < strings.ToLower(b.User)
+	},
+	"bandwidth": func(a, b *Session) bool {
+		aBandwidth, _ := strconv.Atoi(a.Bandwidth)
+		bBandwidth, _ := strconv.Atoi(b.Bandwidth)
+		return aBandwidth < bBandwidth
+	},
+	// first keeps the order Tautulli returned, since the sort is stable.
+	"first": func(a, b *Session) bool {
+		return false
+	},
+}
+
+// validSort reports whether key names a supported sort, with or without a
+// leading "-".
+func validSort(key string) bool {
+	_, ok := sessionSorts[strings.TrimPrefix(key, "-")]
+	return ok
+}
+
+// sortSessions orders sessions in place by the given key. A leading "-"
+// reverses the order, so "-progress" puts the streams furthest along first.
+// The sort is stable, so sessions that tie keep the order Tautulli returned.
+func sortSessions(sessions []Session, key string) error {
+	descending := strings.HasPrefix(key, "-")
+	less, ok := sessionSorts[strings.TrimPrefix(key, "-")]
+	if !ok {
+		return fmt.Errorf("unsupported sort %q", key)
+	}
+
+	sort.SliceStable(sessions, func(i, j int) bool {
+		if descending {
+			return less(&sessions[j], &sessions[i])
+		}
+		return less(&sessions[i], &sessions[j])
+	})
+	return nil
+}
+
+// sessionKey identifies a stream for de-duplication.
+func sessionKey(session *Session) string {
+	return strings.Join([]string{session.Server, session.User, session.GrandparentTitle, session.Title, session.Player}, "\x00")
+}
+
+// sessionID returns a short ID for a session that stays the same between
+// refreshes, so the markup can give its elements stable IDs. It is based on
+// Tautulli's session key where there is one, and is hashed so that it doesn't
+// reveal the user when hide_users is set.
+func sessionID(session *Session, server tautulliServer) string {
+	key := session.SessionKey
+	if key == "" {
+		key = sessionKey(session)
+	}
+	h := fnv.New64a()
+	h.Write([]byte(server.URL + "\x00" + key))
+	return strconv.FormatUint(h.Sum64(), 36)
+}
+
+// dedupeSessions drops sessions that repeat an earlier one's user, title,
+// and player, keeping whichever has made the most progress. The order of the
+// remaining sessions is preserved.
+func dedupeSessions(sessions []Session) []Session {
+	index := make(map[string]int)
+	var deduped []Session
+	for _, session := range sessions {
+		key := sessionKey(&session)
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(deduped)
+			deduped = append(deduped, session)
+			continue
+		}
+		if deduped[i].ProgressUnknown || (!session.ProgressUnknown && session.Progress > deduped[i].Progress) {
+			deduped[i] = session
+		}
+	}
+	return deduped
+}
+
+// hideUsers replaces the user of each session with name, such as "Someone",
+// and drops their avatars.
+func hideUsers(sessions []Session, name string) {
+	for i := range sessions {
+		sessions[i].User = name
+		sessions[i].UserThumb, sessions[i].AvatarURL = "", ""
+	}
+}
+
+// filterSessions returns the sessions for which keep returns true.
+func filterSessions(sessions []Session, keep func(*Session) bool) []Session {
+	var kept []Session
+	for i := range sessions {
+		if keep(&sessions[i]) {
+			kept = append(kept, sessions[i])
+		}
+	}
+	return kept
+}
+
+// allOf returns a filter keeping sessions that every one of filters keeps.
+func allOf(filters ...func(*Session) bool) func(*Session) bool {
+	return func(session *Session) bool {
+		for _, keep := range filters {
+			if !keep(session) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// byUser returns a filter keeping sessions whose user matches one of users,
+// ignoring case.
+func byUser(users []string) func(*Session) bool {
+	return func(session *Session) bool {
+		for _, user := range users {
+			if strings.EqualFold(session.User, user) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// mediaTypes are the media_type values Tautulli reports for sessions.
+var mediaTypes = map[string]bool{
+	"movie":   true,
+	"episode": true,
+	"track":   true,
+	"clip":    true,
+	"photo":   true,
+	"live":    true,
+}
+
+// knownMediaTypes returns the values in types that are known media types, in
+// lower case. Unknown values are dropped.
+func knownMediaTypes(types []string) []string {
+	var known []string
+	for _, mediaType := range types {
+		if mediaType = strings.ToLower(mediaType); mediaTypes[mediaType] {
+			known = append(known, mediaType)
+		}
+	}
+	return known
+}
+
+// notPaused is a filter dropping paused sessions.
+func notPaused(session *Session) bool {
+	return session.State != "paused"
+}
+
+// byMediaType returns a filter keeping sessions with one of the given media
+// types.
+func byMediaType(types []string) func(*Session) bool {
+	return func(session *Session) bool {
+		for _, mediaType := range types {
+			if strings.EqualFold(session.MediaType, mediaType) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// paginate returns the sessions on the given 1-based page of perPage
+// sessions, along with the page number and total number of pages. Pages wrap
+// around, so any positive page is valid.
+func paginate(sessions []Session, perPage, page int) ([]Session, int, int) {
+	if perPage <= 0 || len(sessions) == 0 {
+		return sessions[:0], 1, 1
+	}
+	pages := (len(sessions) + perPage - 1) / perPage
+	page = (page-1)%pages + 1
+	start := (page - 1) * perPage
+	return sessions[start:min(start+perPage, len(sessions))], page, pages
+}
+
+// defaultPageInterval is how long each page is shown with page=auto.
+const defaultPageInterval = 15 * time.Minute
+
+// parsePage reads the page and page_interval parameters. An empty page means
+// the first page; "auto" picks the page from the current time so successive
+// refreshes rotate through them.
+func parsePage(value, interval string, now time.Time) (int, error) {
+	if value == "" {
+		return 1, nil
+	}
+	if value != "auto" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return 0, errors.New(`page must be a positive integer or "auto"`)
+		}
+		return n, nil
+	}
+
+	every := defaultPageInterval
+	if interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			return 0, errors.New("page_interval must be a duration such as 15m")
+		}
+		every = d
+	}
+	return int(now.UnixNano()/int64(every)) + 1, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func titles(sessions []Session) []string {
+	names := make([]string, len(sessions))
+	for i, session := range sessions {
+		names[i] = session.Title
+	}
+	return names
+}
+
+func sessionsTitled(names ...string) []Session {
+	sessions := make([]Session, len(names))
+	for i, name := range names {
+		sessions[i] = Session{Title: name}
+	}
+	return sessions
+}
+
+func TestPaginate(t *testing.T) {
+	tests := []struct {
+		page      int
+		want      []string
+		wantPage  int
+		wantPages int
+	}{
+		{1, []string{"a", "b"}, 1, 3},
+		{2, []string{"c", "d"}, 2, 3},
+		{3, []string{"e"}, 3, 3},
+		{4, []string{"a", "b"}, 1, 3}, // Wraps around
+		{8, []string{"c", "d"}, 2, 3},
+	}
+	for _, tt := range tests {
+		got, page, pages := paginate(sessionsTitled("a", "b", "c", "d", "e"), 2, tt.page)
+		if !slices.Equal(titles(got), tt.want) || page != tt.wantPage || pages != tt.wantPages {
+			t.Errorf("page %d: got %v, page %d of %d; want %v, page %d of %d", tt.page, titles(got), page, pages, tt.want, tt.wantPage, tt.wantPages)
+		}
+	}
+
+	if got, page, pages := paginate(nil, 2, 3); len(got) != 0 || page != 1 || pages != 1 {
+		t.Errorf("no sessions: got %v, page %d of %d; want nothing, page 1 of 1", titles(got), page, pages)
+	}
+}
+
+func TestParsePage(t *testing.T) {
+	now := time.Unix(3600, 0)
+	tests := []struct {
+		value, interval string
+		want            int
+		wantErr         bool
+	}{
+		{"", "", 1, false},
+		{"3", "", 3, false},
+		{"0", "", 0, true},
+		{"next", "", 0, true},
+		{"auto", "", 5, false},    // 60 minutes in, at 15 minutes a page
+		{"auto", "10m", 7, false}, // 60 minutes in, at 10 minutes a page
+		{"auto", "500ms", 7201, false},
+		{"auto", "0s", 0, true},
+		{"auto", "soon", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parsePage(tt.value, tt.interval, now)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parsePage(%q, %q) = %d, %v; want %d, error %t", tt.value, tt.interval, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSortSessionsKeepsTiesInOrder(t *testing.T) {
+	sessions := []Session{
+		{Title: "a", Progress: 50, Started: "300"},
+		{Title: "b", Progress: 80, Started: "100"},
+		{Title: "c", Progress: 50, Started: "200"},
+		{Title: "d", Progress: 80, Started: "400"},
+		{Title: "e", Progress: 50, Started: "100"},
+	}
+	tests := []struct {
+		key  string
+		want []string
+	}{
+		{"progress", []string{"a", "c", "e", "b", "d"}},
+		{"-progress", []string{"b", "d", "a", "c", "e"}},
+		{"started", []string{"b", "e", "c", "a", "d"}},
+		{"-started", []string{"d", "a", "c", "b", "e"}},
+	}
+	for _, tt := range tests {
+		got := slices.Clone(sessions)
+		if err := sortSessions(got, tt.key); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(titles(got), tt.want) {
+			t.Errorf("sort %q = %v, want %v", tt.key, titles(got), tt.want)
+		}
+	}
+
+	if err := sortSessions(slices.Clone(sessions), "rating"); err == nil {
+		t.Error("unsupported sort key was accepted")
+	}
+}
+
+func TestDedupeSessions(t *testing.T) {
+	sessions := []Session{
+		{User: "alice", Title: "Heat", Player: "TV", Progress: 10},
+		{User: "bob", Title: "Heat", Player: "TV", Progress: 20},
+		{User: "alice", Title: "Heat", Player: "TV", Progress: 30},
+		{User: "alice", Title: "Heat", Player: "iPad", ProgressUnknown: true},
+		{User: "alice", Title: "Heat", Player: "iPad", Progress: 5},
+		{User: "alice", Title: "Heat", Player: "TV", ProgressUnknown: true},
+	}
+	got := dedupeSessions(sessions)
+	want := []Session{sessions[2], sessions[1], sessions[4]}
+	if len(got) != len(want) {
+		t.Fatalf("got %d sessions, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i].User != want[i].User || got[i].Player != want[i].Player || got[i].Progress != want[i].Progress {
+			t.Errorf("session %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestUnknownProgress(t *testing.T) {
+	upstream, _ := fakeTautulli(t, activityBody(
+		`{"session_key":"1","user":"alice","player":"TV","title":"Heat","progress_percent":""}`,
+		`{"session_key":"2","user":"alice","player":"TV","title":"Heat","progress_percent":""}`,
+		`{"session_key":"3","user":"bob","player":"TV","title":"Ronin","progress_percent":"0"}`,
+	))
+	page := decodePage(t, get(configuredServer(upstream), "/"))
+
+	want := map[string]bool{"Heat": true, "Ronin": false}
+	if len(page.Sessions) != len(want) {
+		t.Fatalf("got %d sessions, want the duplicate dropped: %q", len(page.Sessions), titles(page.Sessions))
+	}
+	for _, session := range page.Sessions {
+		if session.ProgressUnknown != want[session.Title] {
+			t.Errorf("%s: progress_unknown = %t, want %t", session.Title, session.ProgressUnknown, want[session.Title])
+		}
+	}
+	if !strings.Contains(readMarkup(t, "full"), "{% unless session.progress_unknown %}") {
+		t.Error("full.liquid shows a progress bar when the progress is unknown")
+	}
+}
+
+func TestSessionIDsAreStable(t *testing.T) {
+	var body atomic.Value
+	body.Store(activityBody(episodeJSON, movieJSON))
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body.Load().(string)))
+	}))
+	defer upstream.Close()
+
+	ids := func() map[string]string {
+		page := decodePage(t, get(configuredServer(upstream), "/"))
+		ids := make(map[string]string)
+		for _, session := range page.Sessions {
+			ids[session.Title] = session.ID
+		}
+		return ids
+	}
+	before := ids()
+	// The next refresh has the progress moved on and the order changed.
+	body.Store(activityBody(
+		strings.Replace(movieJSON, `"progress_percent":"10"`, `"progress_percent":"60"`, 1),
+		strings.Replace(episodeJSON, `"progress_percent":"42"`, `"progress_percent":"43"`, 1),
+	))
+	after := ids()
+
+	if !maps.Equal(before, after) {
+		t.Errorf("IDs changed between refreshes: %v, then %v", before, after)
+	}
+	if before["The Dundies"] == "" || before["The Dundies"] == before["Heat"] {
+		t.Errorf("want distinct IDs for different sessions, got %v", before)
+	}
+	if !strings.Contains(readMarkup(t, "full"), `id="progress-{{ session.id }}" data-key="{{ session.id }}"`) {
+		t.Error("full.liquid doesn't give progress bars stable IDs")
+	}
+}
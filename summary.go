@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// SummaryData is the JSON response of the /summary endpoint.
+type SummaryData struct {
+	StreamCount    int     `json:"stream_count"`
+	TotalBandwidth int     `json:"total_bandwidth"` // Kbps
+	Summary        string  `json:"summary"`         // e.g. "3 streaming · 18 Mbps", or "Idle"
+	Theme          string  `json:"theme"`
+	Timestamp      string  `json:"timestamp"`
+	UpdatedAt      string  `json:"updated_at"` // RFC 3339
+	Stale          bool    `json:"stale"`
+	StaleSince     string  `json:"stale_since,omitempty"`
+	Labels         catalog `json:"labels"` // Messages in the lang parameter's language, for the markup
+}
+
+// formatSummary returns a one-line status for the given stream count and
+// total bandwidth in Kbps.
+func formatSummary(streamCount, totalBandwidth int, labels catalog) string {
+	if streamCount <= 0 {
+		return labels["idle"]
+	}
+	streaming := fmt.Sprintf(labels["streaming"], streamCount)
+	if totalBandwidth <= 0 {
+		return streaming
+	}
+	return fmt.Sprintf("%s · %s Mbps", streaming, formatMbps(totalBandwidth))
+}
+
+// handleSummary returns just the stream count and total bandwidth across the
+// requested servers, for small layouts and mashups that don't show sessions.
+func (s *Server) handleSummary(w http.ResponseWriter, r *http.Request) {
+	servers, err := s.requestServers(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "invalid Tautulli credentials", "handler", "summary", "error", err)
+		return
+	}
+	if err := s.checkServers(servers); err != nil {
+		http.Error(w, "Tautulli host is not allowed", http.StatusForbidden)
+		slog.WarnContext(r.Context(), "rejected Tautulli URL", "handler", "summary", "error", err)
+		return
+	}
+
+	theme := r.URL.Query().Get("theme")
+	if _, ok := themes[theme]; !ok {
+		theme = "light"
+	}
+	labels := s.labels(r.URL.Query().Get("lang"))
+	timezone := r.URL.Query().Get("timezone")
+	timeFormat := s.requestTimeFormat(r)
+
+	var (
+		streamCount    int
+		totalBandwidth int
+		staleSince     time.Time
+		fetchErr       *fetchError
+		responded      int
+	)
+	for _, result := range s.getActivities(r.Context(), servers) {
+		if result.Err != nil {
+			if fetchErr == nil {
+				fetchErr = result.Err
+			}
+			continue
+		}
+		responded++
+
+		data := result.Data.Response.Data
+		streamCount += int(data.StreamCount)
+		totalBandwidth += int(data.TotalBandwidth)
+		if !result.StaleSince.IsZero() && (staleSince.IsZero() || result.StaleSince.Before(staleSince)) {
+			staleSince = result.StaleSince
+		}
+	}
+	if responded == 0 {
+		http.Error(w, fetchErr.Message, fetchErr.Status)
+		return
+	}
+
+	now := time.Now()
+	summary := SummaryData{
+		StreamCount:    streamCount,
+		TotalBandwidth: totalBandwidth,
+		Summary:        formatSummary(streamCount, totalBandwidth, labels),
+		Theme:          theme,
+		Timestamp:      formatTimestamp(now, timezone, timeFormat),
+		UpdatedAt:      now.UTC().Format(time.RFC3339),
+		Labels:         labels,
+	}
+	if !staleSince.IsZero() {
+		summary.Stale = true
+		summary.StaleSince = formatTimestamp(staleSince, timezone, timeFormat)
+	}
+
+	writeJSON(w, r, "summary", summary)
+}
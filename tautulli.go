@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// newHTTPClient returns a client whose transport keeps idle connections to
+// Tautulli open, so polls reuse connections instead of making a new TLS
+// handshake on every request. Connections and redirects are checked against
+// the host restrictions attached with restrictHosts. When insecureSkipVerify
+// is set, certificates aren't verified, so self-signed ones are accepted.
+// Every request is sent with userAgent, or tautulli-trmnl/<version> if it is
+// empty, so Tautulli's logs show where the traffic comes from.
+func newHTTPClient(timeout time.Duration, insecureSkipVerify bool, userAgent string) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 16
+	transport.MaxIdleConnsPerHost = 4
+	transport.IdleConnTimeout = 90 * time.Second
+	transport.DialContext = (&net.Dialer{
+		Timeout:        30 * time.Second,
+		KeepAlive:      30 * time.Second,
+		ControlContext: checkDial,
+	}).DialContext
+	if insecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	if userAgent == "" {
+		userAgent = "tautulli-trmnl/" + version
+	}
+	return &http.Client{Timeout: timeout, Transport: userAgentTransport{transport, userAgent}, CheckRedirect: checkRedirect}
+}
+
+// userAgentTransport sets the User-Agent header on every request.
+type userAgentTransport struct {
+	base      http.RoundTripper
+	userAgent string
+}
+
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.base.RoundTrip(req)
+}
+
+// fetchError describes why fetching activity from Tautulli failed, along with
+// the status code and message to return to the client.
+type fetchError struct {
+	Status  int
+	Message string
+	Err     error
+}
+
+func (e *fetchError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Message, e.Err)
+}
+
+func (e *fetchError) Unwrap() error {
+	return e.Err
+}
+
+// fetchActivity requests the current activity from Tautulli. The request is
+// abandoned if ctx is cancelled.
+func (s *Server) fetchActivity(ctx context.Context, tautulliURL, apiKey string) (TautulliResponse, error) {
+	var data TautulliResponse
+
+	apiURL := fmt.Sprintf("%s/api/v2?apikey=%s&cmd=get_activity", tautulliURL, apiKey)
+	resp, err := s.getWithRetry(ctx, apiURL)
+	if err != nil {
+		return data, connectError(ctx, err)
+	}
+	defer resp.Body.Close()
+
+	if err := s.decodeResponse(resp, &data); err != nil {
+		return data, err
+	}
+
+	// Tautulli reports errors such as an invalid API key in the body.
+	if data.Response.Result != "success" {
+		err := fmt.Errorf("result %q: %s", data.Response.Result, data.Response.Message)
+		return data, &fetchError{http.StatusBadGateway, fmt.Sprintf("Tautulli returned an error: %s", data.Response.Message), err}
+	}
+	return data, nil
+}
+
+// connectError describes a request to Tautulli that got no response.
+func connectError(ctx context.Context, err error) *fetchError {
+	switch {
+	case errors.Is(err, errHostNotAllowed):
+		return &fetchError{http.StatusForbidden, "Tautulli host is not allowed", redactError(err)}
+	case errors.Is(err, errRedirectRefused):
+		return &fetchError{http.StatusBadGateway, "Tautulli redirected to another URL; use that URL instead", redactError(err)}
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return &fetchError{http.StatusGatewayTimeout, "Timed out waiting for Tautulli", redactError(err)}
+	default:
+		return &fetchError{http.StatusInternalServerError, "Failed to connect to Tautulli", redactError(err)}
+	}
+}
+
+// decodeResponse decodes a JSON response from Tautulli into v. It reads at
+// most MaxResponseBytes, so a misbehaving server can't exhaust our memory.
+// Responses that aren't JSON, such as the HTML error page of a web server at
+// the wrong URL, are rejected without being read, and so are error statuses.
+func (s *Server) decodeResponse(resp *http.Response, v any) error {
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" && !isJSON(contentType) {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		err := fmt.Errorf("status %d, content type %q", resp.StatusCode, contentType)
+		return &fetchError{http.StatusBadGateway, fmt.Sprintf("Tautulli returned HTTP %d with %s instead of JSON; check the Tautulli URL", resp.StatusCode, mediaType), err}
+	}
+	body := http.MaxBytesReader(nil, resp.Body, int64(s.cfg.MaxResponseBytes))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// Tautulli explains some failures, such as a wrong API key, in the
+		// message of a JSON body. Only that message is read, so the error can
+		// say what went wrong; the body is never used as data.
+		var failure struct {
+			Response struct {
+				Message string `json:"message"`
+			} `json:"response"`
+		}
+		message := fmt.Sprintf("Tautulli returned HTTP %d", resp.StatusCode)
+		if json.NewDecoder(body).Decode(&failure) == nil && failure.Response.Message != "" {
+			message += ": " + failure.Response.Message
+		}
+		return &fetchError{http.StatusBadGateway, message, fmt.Errorf("status %d", resp.StatusCode)}
+	}
+
+	err := json.NewDecoder(body).Decode(v)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return &fetchError{http.StatusBadGateway, "Tautulli response is too large", err}
+	}
+	if err != nil {
+		return &fetchError{http.StatusInternalServerError, "Failed to parse Tautulli response", err}
+	}
+	return nil
+}
+
+// isJSON reports whether a Content-Type header names JSON.
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// getWithRetry fetches url, retrying connection errors and 5xx responses with
+// exponential backoff. After the final attempt the last response or error is
+// returned as is. Cancelling ctx aborts the request and any further retries.
+// Requests refused by the host restrictions are not retried.
+func (s *Server) getWithRetry(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	delay := time.Duration(s.cfg.RetryBaseDelay)
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		resp, err := s.client.Do(req)
+		observeUpstream(resp, err, time.Since(start))
+		attrs := []any{"tautulli_url", redactURL(url), "attempt", attempt, "duration_ms", time.Since(start).Milliseconds()}
+		if err != nil {
+			attrs = append(attrs, "error", redactError(err))
+		} else {
+			attrs = append(attrs, "status", resp.StatusCode)
+		}
+		slog.DebugContext(ctx, "Tautulli request", attrs...)
+		if attempt >= s.cfg.RetryAttempts || isSchemeMismatch(err) || errors.Is(err, errHostNotAllowed) || errors.Is(err, errRedirectRefused) || (err == nil && resp.StatusCode < http.StatusInternalServerError) {
+			return resp, err
+		}
+		if err == nil {
+			resp.Body.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// observeUpstream records the outcome of a single request to Tautulli.
+func observeUpstream(resp *http.Response, err error, elapsed time.Duration) {
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	upstreamDuration.WithLabelValues(code).Observe(elapsed.Seconds())
+	if err != nil || resp.StatusCode >= http.StatusInternalServerError {
+		upstreamErrors.Inc()
+	}
+}
+
+// isSchemeMismatch reports whether err is from making an HTTPS request to a
+// server that only speaks plain HTTP. Retrying won't help, but retrying over
+// HTTP might.
+func isSchemeMismatch(err error) bool {
+	var recordErr tls.RecordHeaderError
+	return errors.Is(err, http.ErrSchemeMismatch) || errors.As(err, &recordErr)
+}
+
+// isTLSFailure reports whether err could be from a failed TLS handshake,
+// including a server that closes the connection when it gets one.
+func isTLSFailure(err error) bool {
+	var (
+		alertErr tls.AlertError
+		certErr  *tls.CertificateVerificationError
+	)
+	return isSchemeMismatch(err) || errors.As(err, &alertErr) || errors.As(err, &certErr) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
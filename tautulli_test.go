@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDecodeActivityFixture(t *testing.T) {
+	raw, err := os.ReadFile("testdata/get_activity.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data TautulliResponse
+	if err := json.Unmarshal(raw, &data); err != nil {
+		t.Fatalf("failed to decode fixture: %v", err)
+	}
+	if got := int(data.Response.Data.StreamCount); got != 4 {
+		t.Errorf("stream_count = %d, want 4", got)
+	}
+	if got := int(data.Response.Data.TotalBandwidth); got != 20320 {
+		t.Errorf("total_bandwidth = %d, want 20320", got)
+	}
+
+	server := tautulliServer{URL: "http://tautulli.example:8181", APIKey: "key"}
+	opts := renderOptions{
+		Layout:      getLayout("full"),
+		Theme:       "light",
+		Placeholder: defaultPlaceholderURL,
+		Labels:      builtinCatalogs[defaultLang],
+	}
+	poster := func(thumb string) string {
+		return server.URL + "/api/v2?apikey=key&cmd=pms_image_proxy&img=" + url.QueryEscape(thumb) + "&width=120&height=180"
+	}
+	want := []struct {
+		title     string
+		progress  int
+		posterURL string
+		transcode string
+	}{
+		{"The Dundies", 42, poster("/library/metadata/48213/thumb/1699999999"), "Direct Play"},
+		{"Inception", 90, poster("/library/metadata/51234/thumb/1699999999"), "Transcode"},
+		{"Airbag", 10, poster("/library/metadata/61000/thumb/1699999999"), "Direct Stream"},
+		{"Pilot", 25, poster("/library/metadata/70011/thumb/1699999999"), "Transcode"}, // No progress_percent
+	}
+
+	sessions := data.Response.Data.Sessions
+	if len(sessions) != len(want) {
+		t.Fatalf("got %d sessions, want %d", len(sessions), len(want))
+	}
+	for i, w := range want {
+		session := sessions[i]
+		enrichSession(&session, server, opts)
+		if session.Title != w.title {
+			t.Errorf("session %d: title = %q, want %q", i, session.Title, w.title)
+		}
+		if session.Progress != w.progress {
+			t.Errorf("%s: progress = %d, want %d", w.title, session.Progress, w.progress)
+		}
+		if session.PosterURL != w.posterURL {
+			t.Errorf("%s: poster_url = %q, want %q", w.title, session.PosterURL, w.posterURL)
+		}
+		if session.TranscodeLabel != w.transcode {
+			t.Errorf("%s: transcode_label = %q, want %q", w.title, session.TranscodeLabel, w.transcode)
+		}
+	}
+}
+
+func TestRetriesFlakyServer(t *testing.T) {
+	var requests atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= 2 {
+			http.Error(w, "try again", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, activityBody(episodeJSON))
+	}))
+	defer upstream.Close()
+
+	cfg := testConfig()
+	cfg.TautulliURL = upstream.URL
+	cfg.APIKey = "key"
+	cfg.RetryAttempts = 3
+	cfg.RetryBaseDelay = Duration(time.Millisecond)
+
+	page := decodePage(t, get(newServer(cfg), "/"))
+	if page.StreamCount != 1 {
+		t.Errorf("stream_count = %d, want 1", page.StreamCount)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("Tautulli got %d requests, want 3", n)
+	}
+}
+
+func TestGivesUpAfterRetryAttempts(t *testing.T) {
+	var requests atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer upstream.Close()
+
+	cfg := testConfig()
+	cfg.TautulliURL = upstream.URL
+	cfg.APIKey = "key"
+	cfg.RetryAttempts = 2
+	cfg.RetryBaseDelay = Duration(time.Millisecond)
+
+	if rec := get(newServer(cfg), "/"); rec.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want 502", rec.Code)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("Tautulli got %d requests, want 2", n)
+	}
+}
+
+func TestConnectionsAreReused(t *testing.T) {
+	var connections atomic.Int32
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, activityBody(episodeJSON))
+	}))
+	upstream.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	upstream.Start()
+	defer upstream.Close()
+
+	cfg := testConfig()
+	cfg.TautulliURL = upstream.URL
+	cfg.APIKey = "key"
+	cfg.CacheTTL = 0
+	s := newServer(cfg)
+
+	for range 3 {
+		decodePage(t, get(s, "/"))
+	}
+	if n := connections.Load(); n != 1 {
+		t.Errorf("three polls opened %d connections, want 1", n)
+	}
+}
+
+func TestCancelledRequestStopsUpstreamCall(t *testing.T) {
+	cancelled := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		close(cancelled)
+	}))
+	defer upstream.Close()
+	s := configuredServer(upstream)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	done := make(chan struct{})
+	go func() {
+		s.routes().ServeHTTP(httptest.NewRecorder(), req)
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the handler kept waiting for Tautulli after the request was cancelled")
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Error("the call to Tautulli wasn't cancelled")
+	}
+}
+
+func TestInsecureSkipVerify(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(activityBody(episodeJSON)))
+	}))
+	defer upstream.Close()
+
+	for _, insecure := range []bool{false, true} {
+		cfg := testConfig()
+		cfg.TautulliURL = upstream.URL
+		cfg.APIKey = "key"
+		cfg.InsecureSkipVerify = insecure
+
+		rec := get(newServer(cfg), "/")
+		if insecure && rec.Code != http.StatusOK {
+			t.Errorf("with INSECURE_SKIP_VERIFY: status = %d, want 200", rec.Code)
+		}
+		if !insecure && rec.Code == http.StatusOK {
+			t.Error("a self-signed certificate was accepted by default")
+		}
+	}
+}
+
+func TestBasePath(t *testing.T) {
+	var paths []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(activityBody()))
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		url, basePath, want string
+	}{
+		{upstream.URL, "", "/api/v2"},
+		{upstream.URL + "/", "", "/api/v2"},
+		{upstream.URL, "/tautulli/", "/tautulli/api/v2"},
+		{upstream.URL + "/proxied/", "tautulli", "/proxied/api/v2"}, // The URL's own path wins
+	}
+	for _, tt := range tests {
+		paths = nil
+		cfg := testConfig()
+		cfg.TautulliURL = tt.url
+		cfg.APIKey = "key"
+		cfg.BasePath = tt.basePath
+
+		decodePage(t, get(newServer(cfg), "/"))
+		if len(paths) != 1 || paths[0] != tt.want {
+			t.Errorf("URL %q with base path %q: requested %q, want %q", tt.url, tt.basePath, paths, tt.want)
+		}
+	}
+}
+
+func TestOversizedResponse(t *testing.T) {
+	upstream, _ := fakeTautulli(t, activityBody(numberedSessions(50)...))
+	cfg := testConfig()
+	cfg.TautulliURL = upstream.URL
+	cfg.APIKey = "key"
+	cfg.MaxResponseBytes = 1024
+
+	rec := get(newServer(cfg), "/")
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want 502", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "too large") {
+		t.Errorf("body = %q, want it to say the response is too large", rec.Body)
+	}
+}
+
+func TestNonJSONResponses(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		want        string
+	}{
+		{"HTML 404 page", http.StatusNotFound, "text/html; charset=utf-8", "<html><body><h1>404 Not Found</h1></body></html>", "Tautulli returned HTTP 404 with text/html instead of JSON"},
+		{"error without a content type", http.StatusUnauthorized, "", "Unauthorized", "Tautulli returned HTTP 401"},
+		{"JSON error that decodes", http.StatusServiceUnavailable, "application/json", `{"error":"maintenance"}`, "Tautulli returned HTTP 503"},
+		{"JSON error with a message", http.StatusUnauthorized, "application/json", `{"response":{"result":"error","message":"Invalid apikey"}}`, "Tautulli returned HTTP 401: Invalid apikey"},
+		{"error with activity data", http.StatusInternalServerError, "application/json", activityBody(episodeJSON), "Tautulli returned HTTP 500"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				} else {
+					w.Header()["Content-Type"] = nil
+				}
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			}))
+			defer upstream.Close()
+
+			rec := get(configuredServer(upstream), "/")
+			if rec.Code != http.StatusBadGateway {
+				t.Errorf("status = %d, want 502", rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("body = %q, want it to contain %q", rec.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	var userAgents []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents = append(userAgents, r.URL.Query().Get("cmd")+" "+r.UserAgent())
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, activityBody())
+	}))
+	defer upstream.Close()
+
+	for _, configured := range []string{"", "my-frame/2.0"} {
+		userAgents = nil
+		cfg := testConfig()
+		cfg.TautulliURL = upstream.URL
+		cfg.APIKey = "key"
+		cfg.UserAgent = configured
+		want := configured
+		if want == "" {
+			want = "tautulli-trmnl/" + version
+		}
+
+		get(newServer(cfg), "/?history=true")
+		if len(userAgents) != 2 {
+			t.Fatalf("Tautulli got %q, want get_activity and get_history requests", userAgents)
+		}
+		for _, got := range userAgents {
+			if cmd, userAgent, _ := strings.Cut(got, " "); userAgent != want {
+				t.Errorf("%s: User-Agent = %q, want %q", cmd, userAgent, want)
+			}
+		}
+	}
+}
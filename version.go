@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// Build information, set at build time with
+//
+//	go build -ldflags "-X main.version=v1.2.3 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = "dev"
+	buildDate = "dev"
+)
+
+// versionString describes the build for the -version flag.
+func versionString() string {
+	return fmt.Sprintf("tautulli-trmnl %s (commit %s, built %s)", version, commit, buildDate)
+}
+
+// handleVersion returns the build information as JSON.
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(map[string]string{
+		"version":    version,
+		"commit":     commit,
+		"build_date": buildDate,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to encode version response", "error", err)
+	}
+}